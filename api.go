@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// anthropicMaxTokens caps Claude's answers; the API requires a cap.
+	anthropicMaxTokens = 8192
+	anthropicVersion   = "2023-06-01"
+	// ollamaMinContext is the smallest context window asked of Ollama,
+	// whose own default is too small for most prompts.
+	ollamaMinContext = 8192
+	// maxStreamLine bounds one line of a streamed response.
+	maxStreamLine = 1 << 20
+)
+
+// provider is an API the prompt can be sent to: where it lives, the
+// environment variable holding its key, if it needs one, and the model
+// used when none is named. send streams the answer to emit as it arrives.
+type provider struct {
+	base   string
+	keyEnv string
+	model  string
+	send   func(ctx context.Context, base, key, model, system string, messages []chatMessage, emit func(string)) error
+}
+
+var providers = map[string]provider{
+	"openai":    {base: "https://api.openai.com/v1", keyEnv: "OPENAI_API_KEY", model: "gpt-4o", send: sendOpenAI},
+	"anthropic": {base: "https://api.anthropic.com/v1", keyEnv: "ANTHROPIC_API_KEY", model: "claude-sonnet-4-5", send: sendAnthropic},
+	"ollama":    {base: ollamaHost(), model: "llama3.1", send: sendOllama},
+}
+
+// ollamaHost is where a local Ollama server listens: $OLLAMA_HOST, as the
+// ollama CLI reads it, or its default address.
+func ollamaHost() string {
+	host := os.Getenv("OLLAMA_HOST")
+	switch {
+	case host == "":
+		return "http://localhost:11434"
+	case !strings.Contains(host, "://"):
+		return "http://" + host
+	}
+	return host
+}
+
+// chatMessage is one turn of a conversation with a model.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// sendOpenAI streams a reply from the chat completions endpoint under
+// base, sending the system prompt as a system message.
+func sendOpenAI(ctx context.Context, base, key, model, system string, messages []chatMessage, emit func(string)) error {
+	if system != "" {
+		messages = append([]chatMessage{{Role: "system", Content: system}}, messages...)
+	}
+	resp, err := postJSON(ctx, strings.TrimSuffix(base, "/")+"/chat/completions", map[string]string{"Authorization": "Bearer " + key},
+		map[string]any{"model": model, "messages": messages, "stream": true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readEvents(resp.Body, func(data string) error {
+		var ev struct {
+			Choices []struct {
+				Delta chatMessage `json:"delta"`
+			} `json:"choices"`
+			Error *apiErrorBody `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return err
+		}
+		if ev.Error != nil {
+			return errors.New(ev.Error.Message)
+		}
+		for _, c := range ev.Choices {
+			emit(c.Delta.Content)
+		}
+		return nil
+	})
+}
+
+// sendAnthropic streams a reply from the Messages API under base, with
+// the system prompt in its own field.
+func sendAnthropic(ctx context.Context, base, key, model, system string, messages []chatMessage, emit func(string)) error {
+	body := map[string]any{"model": model, "max_tokens": anthropicMaxTokens, "messages": messages, "stream": true}
+	if system != "" {
+		body["system"] = system
+	}
+	resp, err := postJSON(ctx, strings.TrimSuffix(base, "/")+"/messages", map[string]string{"x-api-key": key, "anthropic-version": anthropicVersion}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readEvents(resp.Body, func(data string) error {
+		var ev struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Error *apiErrorBody `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return err
+		}
+		switch {
+		case ev.Error != nil:
+			return errors.New(ev.Error.Message)
+		case ev.Type == "content_block_delta" && ev.Delta.Type == "text_delta":
+			emit(ev.Delta.Text)
+		}
+		return nil
+	})
+}
+
+// sendOllama streams a reply from an Ollama server's chat endpoint,
+// sending the system prompt as a system message. Nothing leaves the
+// machine unless base points elsewhere.
+func sendOllama(ctx context.Context, base, _, model, system string, messages []chatMessage, emit func(string)) error {
+	if system != "" {
+		messages = append([]chatMessage{{Role: "system", Content: system}}, messages...)
+	}
+	// Ollama silently drops what doesn't fit its context window, so ask
+	// for one that holds the prompt with room for the answer
+	size := 0
+	for _, msg := range messages {
+		size += estimateTokens(msg.Content)
+	}
+	numCtx := max(ollamaMinContext, (size+4096+1023)/1024*1024)
+	resp, err := postJSON(ctx, strings.TrimSuffix(base, "/")+"/api/chat", nil,
+		map[string]any{"model": model, "messages": messages, "stream": true, "options": map[string]any{"num_ctx": numCtx}})
+	if err != nil {
+		return fmt.Errorf("%w (is ollama serve running?)", err)
+	}
+	defer resp.Body.Close()
+	// the stream is one JSON object per line
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, maxStreamLine)
+	for sc.Scan() {
+		var ev struct {
+			Message chatMessage `json:"message"`
+			Done    bool        `json:"done"`
+			Error   string      `json:"error"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			return err
+		}
+		if ev.Error != "" {
+			return errors.New(ev.Error)
+		}
+		emit(ev.Message.Content)
+		if ev.Done {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// postJSON posts body as JSON to url with the given headers. A response
+// other than 200 OK is returned as an error carrying the API's message.
+func postJSON(ctx context.Context, url string, headers map[string]string, body any) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp, nil
+}
+
+// apiErrorBody is how OpenAI and Anthropic describe an error.
+type apiErrorBody struct {
+	Message string `json:"message"`
+}
+
+// apiError describes a failed response by its status and the message in
+// its body, which is {"error": {"message": ...}} or, from Ollama,
+// {"error": "..."}.
+func apiError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxStreamLine))
+	var nested struct {
+		Error apiErrorBody `json:"error"`
+	}
+	var flat struct {
+		Error string `json:"error"`
+	}
+	switch {
+	case json.Unmarshal(b, &nested) == nil && nested.Error.Message != "":
+		return fmt.Errorf("%s: %s", resp.Status, nested.Error.Message)
+	case json.Unmarshal(b, &flat) == nil && flat.Error != "":
+		return fmt.Errorf("%s: %s", resp.Status, flat.Error)
+	}
+	return errors.New(resp.Status)
+}
+
+// readEvents calls fn with the data of each server-sent event in r until
+// the stream ends or sends [DONE].
+func readEvents(r io.Reader, fn func(data string) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxStreamLine)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// splitChunks splits prompt between the files starting on the lines in
+// starts into chunks of at most size bytes, one file per chunk when size
+// is 0. What precedes the first file is a block of its own, and what
+// follows the last stays with it. A block larger than size gets a chunk of
+// its own. Each chunk is labelled with its position.
+func splitChunks(prompt string, starts []int, size int) []string {
+	cut := map[int]bool{}
+	for _, line := range starts {
+		if line > 0 {
+			cut[line] = true
+		}
+	}
+	var blocks []string
+	start, offset := 0, 0
+	for i, line := range strings.SplitAfter(prompt, "\n") {
+		if cut[i] && offset > start {
+			blocks = append(blocks, prompt[start:offset])
+			start = offset
+		}
+		offset += len(line)
+	}
+	blocks = append(blocks, prompt[start:])
+
+	var chunks []string
+	var cur strings.Builder
+	for _, b := range blocks {
+		if cur.Len() > 0 && (size <= 0 || cur.Len()+len(b) > size) {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+		}
+		cur.WriteString(b)
+	}
+	chunks = append(chunks, cur.String())
+	for i := range chunks {
+		chunks[i] = fmt.Sprintf("(part %d of %d)\n", i+1, len(chunks)) + strings.TrimSuffix(chunks[i], "\n")
+	}
+	return chunks
+}
+
+// copyNextChunk copies the next chunk of the prompt without quitting,
+// splitting the prompt on first use and wrapping around after the last.
+func (m *model) copyNextChunk() tea.Cmd {
+	if m.commandsPending() {
+		return m.flash("Waiting for the commands to finish")
+	}
+	if m.chunks == nil {
+		d := gatherPrompt(m.root, selectedFiles(m.root), m.textarea.Value(), m.promptOptions())
+		prompt := renderPrompt(d)
+		if m.warning = m.budgetError(prompt); m.warning != "" {
+			return nil
+		}
+		m.chunks = splitChunks(prompt, fileStarts(d, prompt), int(m.opts.ChunkSize))
+		m.chunkIndex = 0
+	}
+	if m.chunkIndex >= len(m.chunks) {
+		m.chunkIndex = 0
+	}
+	if err := copyToClipboard(m.chunks[m.chunkIndex], m.opts); err != nil {
+		m.warning = "copy: " + err.Error()
+		return nil
+	}
+	m.chunkIndex++
+	return m.flash(fmt.Sprintf("Copied chunk %d/%d", m.chunkIndex, len(m.chunks)))
+}
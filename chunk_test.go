@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitChunksAtFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"notes.md": "# Notes\n\n### Setup\n\n## Request\n\n<file>\nstill notes\n",
+		"main.go":  "package main\n\n// ### not a heading\nfunc main() {}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := &node{path: dir, isDir: true, expanded: true}
+	loadChildren(root, nil)
+	var paths []string
+	for _, c := range root.children {
+		c.selected = true
+		paths = append(paths, c.path)
+	}
+	for _, format := range []string{"xml", "markdown", "repomix", "json"} {
+		t.Run(format, func(t *testing.T) {
+			opts := options{Format: format, PathBase: "root", Model: "chars"}
+			d := gatherPrompt(root, paths, "do it", opts)
+			prompt := renderPrompt(d)
+			chunks := splitChunks(prompt, fileStarts(d, prompt), 0)
+			// the tree and other preamble, then one chunk per file
+			if len(chunks) != len(files)+1 {
+				t.Fatalf("got %d chunks, want %d:\n%s", len(chunks), len(files)+1, strings.Join(chunks, "\n----\n"))
+			}
+			for i, f := range d.files {
+				chunk := chunks[i+1]
+				if !strings.Contains(chunk, f.path) {
+					t.Errorf("chunk %d doesn't name %s:\n%s", i+2, f.path, chunk)
+				}
+				if format != "json" && !strings.Contains(chunk, strings.TrimSuffix(f.content, "\n")) {
+					t.Errorf("chunk %d splits %s:\n%s", i+2, f.path, chunk)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// copyToClipboard writes text to the clipboard: through the terminal with
+// --clipboard osc52, into a tmux paste buffer with --clipboard tmux,
+// otherwise with the first working utility for the platform. On X11 and
+// Wayland, --clipboard-selection chooses between the "clipboard" and
+// "primary" selections.
+func copyToClipboard(text string, opts options) error {
+	switch opts.Clipboard {
+	case "osc52":
+		return copyOSC52(text, opts)
+	case "tmux":
+		return copyTmux(text)
+	}
+	cmds := clipboardCommands(opts.ClipboardSelection)
+	var tried, failed []string
+	for _, args := range cmds {
+		tried = append(tried, args[0])
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		// e.g. xclip is installed but there is no display; try the next
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		failed = append(failed, args[0]+": "+msg)
+	}
+	if len(failed) > 0 {
+		return errors.New("copy failed: " + strings.Join(failed, "; "))
+	}
+	return fmt.Errorf("no clipboard utility found (tried %s); over SSH try --clipboard osc52", strings.Join(tried, ", "))
+}
+
+// clipboardCommands lists the copy commands to try, best first.
+func clipboardCommands(selection string) [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{
+			{"clip.exe"},
+			{"powershell.exe", "-NoProfile", "-Command", "$input | Set-Clipboard"},
+		}
+	}
+	var cmds [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		wl := []string{"wl-copy"}
+		if selection == "primary" {
+			wl = append(wl, "--primary")
+		}
+		cmds = append(cmds, wl)
+	}
+	cmds = append(cmds,
+		[]string{"xclip", "-selection", selection},
+		[]string{"xsel", "--" + selection, "--input"},
+	)
+	// WSL can reach the Windows clipboard
+	return append(cmds, []string{"clip.exe"})
+}
+
+// copyTmux loads text into tmux's paste buffer, leaving the system
+// clipboard alone; paste it with prefix+].
+func copyTmux(text string) error {
+	if os.Getenv("TMUX") == "" {
+		return errors.New("--clipboard tmux needs to run inside tmux")
+	}
+	cmd := exec.Command("tmux", "load-buffer", "-")
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return errors.New("tmux load-buffer: " + msg)
+		}
+		return fmt.Errorf("tmux load-buffer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	commandTimeout     = 30 * time.Second
+	commandOutputLimit = 64 << 10
+)
+
+// commandResult is the captured output of a configured context command.
+type commandResult struct {
+	command  string
+	output   string
+	exitCode int
+	err      error
+}
+
+// runCommand runs command through the shell in dir, capturing combined
+// stdout and stderr up to commandOutputLimit.
+func runCommand(dir, command string) commandResult {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	res := commandResult{command: command, output: string(out)}
+	if len(out) > commandOutputLimit {
+		res.output = string(out[:commandOutputLimit]) + "\n[... output truncated]"
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		res.err = errors.New("timed out after " + commandTimeout.String())
+	case errors.As(err, &exitErr):
+		res.exitCode = exitErr.ExitCode()
+	case err != nil:
+		res.err = err
+	}
+	return res
+}
+
+// commandCache holds the output of the --cmd commands for the review, so
+// they run once rather than on every render; ctrl+r runs them again.
+type commandCache struct {
+	seq     int
+	running bool
+	results map[string]commandResult
+	// copyAfter copies the prompt once the commands finish.
+	copyAfter bool
+}
+
+// commandsMsg carries the output of a run of the --cmd commands.
+type commandsMsg struct {
+	seq     int
+	results map[string]commandResult
+}
+
+// startCommands runs the --cmd commands in the background unless their
+// output is cached or they are already running.
+func (m *model) startCommands() tea.Cmd {
+	c := &m.commands
+	if len(m.opts.Commands) == 0 || c.results != nil || c.running {
+		return nil
+	}
+	c.running = true
+	c.seq++
+	seq, dir, commands := c.seq, m.root.path, m.opts.Commands
+	return func() tea.Msg {
+		results := make(map[string]commandResult, len(commands))
+		for _, command := range commands {
+			results[command] = runCommand(dir, command)
+		}
+		return commandsMsg{seq: seq, results: results}
+	}
+}
+
+// handleCommands caches the commands' output and refreshes the review.
+func (m *model) handleCommands(msg commandsMsg) tea.Cmd {
+	if msg.seq != m.commands.seq {
+		return nil
+	}
+	m.commands.running = false
+	m.commands.results = msg.results
+	if m.focus == acceptView {
+		offset := m.viewport.YOffset
+		m.renderReview()
+		m.viewport.SetYOffset(offset)
+	}
+	if m.commands.copyAfter {
+		m.commands.copyAfter = false
+		return m.copyFresh()
+	}
+	return nil
+}
+
+// commandsPending reports whether the prompt still lacks its commands'
+// output.
+func (m model) commandsPending() bool {
+	return len(m.opts.Commands) > 0 && m.commands.results == nil
+}
+
+// promptOptions are the options the TUI builds prompts with: the
+// commands' cached output is used, and until it arrives they are shown as
+// still running.
+func (m model) promptOptions() options {
+	opts := m.opts
+	opts.ran = m.commands.results
+	if opts.ran == nil && len(opts.Commands) > 0 {
+		opts.ran = map[string]commandResult{}
+		for _, c := range opts.Commands {
+			opts.ran[c] = commandResult{command: c, err: errors.New("still running")}
+		}
+	}
+	return opts
+}
+
+// shellCommand runs command through the platform's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// pipePrompt runs command in dir with prompt on its stdin, streaming its
+// output to path, or to the terminal when path is empty.
+func pipePrompt(dir, command, prompt, path string) error {
+	cmd := shellCommand(context.Background(), command)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(prompt)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cmd.Stdout = f
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", command, err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// configFileName is the per-project config file, read from the root.
+const configFileName = ".ctx-tui.json"
+
+// loadConfig overlays the keys present in the JSON file at path onto opts.
+// A missing file is not an error.
+func loadConfig(path string, opts *options) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, opts); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// saveConfigValue sets key in the config file at path, leaving other keys
+// untouched. It does nothing if the file doesn't exist.
+func saveConfigValue(path, key string, value any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	cfg := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	cfg[key] = v
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0o644)
+}
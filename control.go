@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+)
+
+// maxControlRead caps how much of the control file is read per change, so
+// a runaway writer can't stall the UI.
+const maxControlRead = 1 << 20
+
+// control lets another process, such as an editor, change the selection
+// by appending lines to a file the TUI watches:
+//
+//	select <path>
+//	deselect <path>
+//	clear
+//
+// Relative paths resolve against the root. Blank lines and lines starting
+// with # are ignored; anything else is reported and skipped.
+type control struct {
+	path    string
+	watcher *fsnotify.Watcher
+	// offset is how far the file has been applied; only complete lines
+	// after it are read.
+	offset int64
+}
+
+// controlMsg carries the complete lines appended to the control file.
+type controlMsg []string
+
+type controlErrMsg error
+
+// newControl watches path, creating it if needed. Commands already in the
+// file are not replayed.
+func newControl(path string) (*control, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(abs, os.O_RDONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// watch the directory so editors that replace the file are still seen
+	if err := w.Add(filepath.Dir(abs)); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &control{path: abs, watcher: w, offset: info.Size()}, nil
+}
+
+func (c *control) wait() tea.Cmd {
+	if c == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		for {
+			select {
+			case ev, ok := <-c.watcher.Events:
+				if !ok {
+					return nil
+				}
+				if ev.Name != c.path || !ev.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				lines, err := c.read()
+				if err != nil {
+					return controlErrMsg(err)
+				}
+				if len(lines) > 0 {
+					return controlMsg(lines)
+				}
+			case err, ok := <-c.watcher.Errors:
+				if !ok {
+					return nil
+				}
+				return controlErrMsg(err)
+			}
+		}
+	}
+}
+
+// read returns the complete lines written since the last read. A file
+// that shrank was truncated or replaced and is read from the start.
+func (c *control) read() ([]string, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < c.offset {
+		c.offset = 0
+	}
+	if _, err := f.Seek(c.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(io.LimitReader(f, maxControlRead))
+	if err != nil {
+		return nil, err
+	}
+	end := bytes.LastIndexByte(b, '\n')
+	if end < 0 {
+		if len(b) == maxControlRead {
+			// a single line longer than the cap can never complete; drop it
+			c.offset += int64(len(b))
+			return nil, fmt.Errorf("control: line longer than %d bytes skipped", maxControlRead)
+		}
+		return nil, nil
+	}
+	c.offset += int64(end + 1)
+	return strings.Split(string(b[:end]), "\n"), nil
+}
+
+func (c *control) close() {
+	if c != nil {
+		c.watcher.Close()
+	}
+}
+
+// applyControl runs control commands against the tree and returns a
+// summary for the footer and the lines that were rejected.
+func (m *model) applyControl(lines []string) (summary string, bad []string) {
+	selected, deselected := 0, 0
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		p := arg
+		if p != "" && !filepath.IsAbs(p) {
+			p = filepath.Join(m.root.path, p)
+		}
+		switch {
+		case cmd == "select" && arg != "":
+			n := revealPath(m.root, filepath.Clean(p), m.watcher)
+			if n == nil || n.isDir {
+				bad = append(bad, fmt.Sprintf("line %d: no such file %q", i+1, arg))
+				continue
+			}
+			n.selected = true
+			selected++
+		case cmd == "deselect" && arg != "":
+			if n := findNode(m.root, filepath.Clean(p)); n != nil && n.selected {
+				n.toggleSelect(false)
+				deselected++
+			}
+		case cmd == "clear" && arg == "":
+			m.root.clearSelection()
+			m.root.clearIncluded()
+			deselected++
+		default:
+			bad = append(bad, fmt.Sprintf("line %d: malformed %q", i+1, line))
+		}
+	}
+	m.reflatten()
+	return fmt.Sprintf("Control: %d selected, %d deselected", selected, deselected), bad
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// modelPrices are list prices in US dollars per million input tokens by
+// model name prefix, most specific first. The prices config key adds to
+// and overrides them.
+var modelPrices = []struct {
+	prefix string
+	price  float64
+}{
+	{"gpt-4o-mini", 0.15},
+	{"gpt-4o", 2.50},
+	{"gpt-4.1-nano", 0.10},
+	{"gpt-4.1-mini", 0.40},
+	{"gpt-4.1", 2.00},
+	{"o3-mini", 1.10},
+	{"o3", 2.00},
+	{"o4-mini", 1.10},
+	{"claude-opus", 15.00},
+	{"claude-sonnet", 3.00},
+	{"claude-haiku-4", 1.00},
+	{"claude-3-5-haiku", 0.80},
+	{"claude-3-haiku", 0.25},
+}
+
+// inputPrice returns what a million input tokens cost for model. Prices
+// from the config win, the longest matching prefix among them first.
+func (o options) inputPrice(model string) (float64, bool) {
+	best, found := "", false
+	for prefix := range o.Prices {
+		if strings.HasPrefix(model, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	if found {
+		return o.Prices[best], true
+	}
+	for _, mp := range modelPrices {
+		if strings.HasPrefix(model, mp.prefix) {
+			return mp.price, true
+		}
+	}
+	return 0, false
+}
+
+// sendCost estimates what sending tokens input tokens costs at the
+// send target. ok is false for a local server, which is free, and for
+// models without a known price.
+func (o options) sendCost(tokens int) (cost float64, ok bool) {
+	name, _, model := o.sendTarget()
+	if name == "ollama" {
+		return 0, false
+	}
+	price, ok := o.inputPrice(model)
+	return float64(tokens) * price / 1e6, ok
+}
+
+// sendTokens counts s with the tokenizer of the model prompts are sent
+// to, or of --model if that one is unknown.
+func (o options) sendTokens(s string) int {
+	_, _, model := o.sendTarget()
+	if name, ok := tokenizerFor(model); ok {
+		return tokenizers[name](s)
+	}
+	return o.countTokens(s)
+}
+
+// formatCost writes a dollar amount to the cent.
+func formatCost(cost float64) string {
+	if cost < 0.01 {
+		return "<$0.01"
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
+
+// confirmCost runs send at once, or after asking if the input cost of
+// messages and the system prompt is estimated above --confirm-cost.
+func (m *model) confirmCost(messages []chatMessage, send func(m *model) tea.Cmd) tea.Cmd {
+	tokens := m.opts.sendTokens(m.opts.systemPrompt())
+	for _, msg := range messages {
+		tokens += m.opts.sendTokens(msg.Content)
+	}
+	cost, ok := m.opts.sendCost(tokens)
+	if !ok || m.opts.ConfirmCost <= 0 || cost <= m.opts.ConfirmCost {
+		return send(m)
+	}
+	_, _, model := m.opts.sendTarget()
+	m.confirm = &confirmation{
+		prompt: fmt.Sprintf("Send %s tokens to %s for about %s?", formatCount(tokens), model, formatCost(cost)),
+		accept: send,
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// exportHeader starts an exported prompt and names its encoding, so a
+// future encoding can be told apart.
+const exportHeader = "ctx-tui export gzip+base64 v1"
+
+// writeExport writes prompt to path gzipped and base64 encoded, wrapped
+// at 76 columns under exportHeader. `ctx-tui decode` reverses it.
+func writeExport(path, prompt string) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(prompt))
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	enc := base64.StdEncoding.EncodeToString(buf.Bytes())
+	var sb strings.Builder
+	sb.WriteString(exportHeader + "\n")
+	for len(enc) > 76 {
+		sb.WriteString(enc[:76] + "\n")
+		enc = enc[76:]
+	}
+	sb.WriteString(enc + "\n")
+	return writeFileAtomic(path, []byte(sb.String()))
+}
+
+// decodeExport returns the prompt held in the text of an export.
+func decodeExport(data string) (string, error) {
+	header, body, _ := strings.Cut(strings.TrimLeft(data, " \t\r\n"), "\n")
+	if strings.TrimSpace(header) != exportHeader {
+		return "", errors.New("not a ctx-tui export (missing header line)")
+	}
+	z, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// runDecode implements `ctx-tui decode [file]`, printing the prompt in a
+// file written by --export, or in standard input.
+func runDecode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ctx-tui decode [file]\n\nPrints the prompt in a file written by --export; reads standard input without a file or with -.")
+	}
+	fs.Parse(args)
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	prompt, err := decodeExport(string(data))
+	if err != nil {
+		return err
+	}
+	fmt.Println(prompt)
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// extFilter narrows the tree to files with the given extensions until it
+// is cleared.
+type extFilter struct {
+	editing bool
+	input   textinput.Model
+	exts    map[string]bool
+}
+
+// openExtFilter prompts for a list of extensions such as "go,md".
+func (m *model) openExtFilter() tea.Cmd {
+	ti := textinput.New()
+	ti.Prompt = "Show extensions: "
+	ti.Placeholder = "go,md"
+	var exts []string
+	for ext := range m.extFilter.exts {
+		exts = append(exts, strings.TrimPrefix(ext, "."))
+	}
+	slices.Sort(exts)
+	ti.SetValue(strings.Join(exts, ","))
+	m.extFilter.input = ti
+	m.extFilter.editing = true
+	return m.extFilter.input.Focus()
+}
+
+// updateExtFilter handles a key while the prompt is open: enter applies
+// the list, esc leaves the filter as it was.
+func (m *model) updateExtFilter(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.extFilter.editing = false
+		return nil
+	case "enter":
+		m.extFilter.editing = false
+		exts := map[string]bool{}
+		for _, e := range strings.FieldsFunc(m.extFilter.input.Value(), func(r rune) bool { return r == ',' || r == ' ' }) {
+			exts[normalizeExt(e)] = true
+		}
+		if len(exts) == 0 {
+			return m.clearExtFilter()
+		}
+		m.extFilter.exts = exts
+		m.applyFilterMode()
+		m.reflatten()
+		return nil
+	}
+	var cmd tea.Cmd
+	m.extFilter.input, cmd = m.extFilter.input.Update(msg)
+	return cmd
+}
+
+func (m *model) clearExtFilter() tea.Cmd {
+	if m.extFilter.exts == nil {
+		return nil
+	}
+	m.extFilter.exts = nil
+	m.applyFilterMode()
+	m.reflatten()
+	return m.flash("Showing all file types")
+}
+
+// extHidden reports whether n is filtered out by the extension filter:
+// files of other types, and loaded directories with no file of a listed
+// type beneath them.
+func (m *model) extHidden(n *node) bool {
+	if len(m.extFilter.exts) == 0 {
+		return false
+	}
+	if !n.isDir {
+		return !m.extFilter.exts[strings.ToLower(filepath.Ext(n.path))]
+	}
+	if !n.childrenLoaded {
+		return false
+	}
+	for _, c := range n.children {
+		if !m.extHidden(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// extLabel lists the filtered extensions for the list title, e.g.
+// " [.go .md]".
+func (m model) extLabel() string {
+	if len(m.extFilter.exts) == 0 {
+		return ""
+	}
+	var exts []string
+	for ext := range m.extFilter.exts {
+		exts = append(exts, ext)
+	}
+	slices.Sort(exts)
+	return " [" + strings.Join(exts, " ") + "]"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const fileUsage = `usage: ctx-tui file <path> [--request "..."] [--strict] [flags]`
+
+// runFile implements `ctx-tui file <path> [--request "..."]`, printing a
+// prompt for a single file without starting the TUI. The prompt follows
+// the project config and the same flags as the TUI.
+func runFile(args []string) error {
+	var request string
+	var strict bool
+	opts, rest, err := parseCommand("file", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&request, "request", "", "user request to include with the file")
+		fs.BoolVar(&strict, "strict", false, "exit with an error if the file can't be read")
+		fs.Usage = func() {
+			fmt.Fprintln(fs.Output(), fileUsage)
+			fs.PrintDefaults()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		fmt.Fprintln(os.Stderr, fileUsage)
+		return errors.New("missing file path")
+	}
+	path := rest[0]
+
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abspath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if strict {
+		if err := checkReadable([]string{abspath}); err != nil {
+			return err
+		}
+	}
+	root := &node{path: filepath.Dir(abspath), isDir: true, expanded: true, childrenLoaded: true}
+	f := &node{path: abspath, parent: root, selected: true}
+	root.children = []*node{f}
+	fmt.Println(buildPrompt(root, []string{abspath}, request, opts))
+	return nil
+}
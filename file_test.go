@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermissionDenied(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(path, []byte("secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(path, 0o644) })
+	if f, err := os.Open(path); err == nil {
+		f.Close()
+		t.Skip("mode 000 files are readable here, e.g. as root")
+	}
+
+	f := promptFile{path: path}
+	f.read(lineRange{}, options{})
+	if f.content != "[Permission denied]" || f.text {
+		t.Errorf("read: content = %q, text = %v; want [Permission denied] and not text", f.content, f.text)
+	}
+
+	if err := checkReadable([]string{path}); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("checkReadable: err = %v, want a permission error", err)
+	}
+	if err := runFile([]string{"--path", dir, "--strict", path}); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("file --strict: err = %v, want a permission error", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// caseSensitiveFilter keeps targets containing term as an exact-case
+// subsequence, preserving tree order rather than ranking by score.
+func caseSensitiveFilter(term string, targets []string) []list.Rank {
+	var ranks []list.Rank
+	for i, t := range targets {
+		var matched []int
+		rest := term
+		for idx, r := range t {
+			if rest == "" {
+				break
+			}
+			want, size := utf8.DecodeRuneInString(rest)
+			if r == want {
+				matched = append(matched, idx)
+				rest = rest[size:]
+			}
+		}
+		if rest == "" {
+			ranks = append(ranks, list.Rank{Index: i, MatchedIndexes: matched})
+		}
+	}
+	return ranks
+}
+
+func (m *model) applyFilterMode() {
+	switch {
+	case m.search.active && m.search.kind == contentFilter:
+		m.list.Title = "Content Filter"
+	case m.search.active:
+		m.list.Title = "Search Results"
+	case m.selectedView:
+		m.list.Title = "Selected Files"
+	case m.opts.Staged:
+		m.list.Title = "Staged Changes"
+	case m.opts.ChangedSince != "":
+		m.list.Title = "Changed Since " + m.opts.ChangedSince
+	default:
+		m.list.Title = "File Tree"
+	}
+	m.list.Title += m.extLabel()
+	if m.caseSensitive {
+		m.list.Filter = caseSensitiveFilter
+		m.list.Title += " [Aa]"
+		m.list.FilterInput.Prompt = "Filter [Aa]: "
+	} else {
+		m.list.Filter = list.DefaultFilter
+		m.list.FilterInput.Prompt = "Filter: "
+	}
+	// re-run an active filter so the visible items reflect the new mode
+	state := m.list.FilterState()
+	if state == list.Unfiltered {
+		return
+	}
+	m.list.SetFilterText(m.list.FilterValue())
+	if state == list.Filtering {
+		m.list.SetFilterState(list.Filtering)
+	}
+}
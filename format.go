@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formats maps --format names to renderers.
+var formats = map[string]func(promptData) string{
+	"xml":      renderXML,
+	"markdown": renderMarkdown,
+	"json":     renderJSON,
+	"repomix":  renderRepomix,
+}
+
+// formatNames lists the formats for messages, e.g. "markdown or xml".
+func formatNames() string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// renderXML emits each part of the prompt in its own tag.
+func renderXML(d promptData) string {
+	var sb strings.Builder
+	if d.system != "" {
+		sb.WriteString("<system_prompt>\n" + d.system + "\n</system_prompt>\n")
+	}
+	for _, f := range d.guidance {
+		sb.WriteString("<project_guidance>\n<file_path>" + f.path + "</file_path>\n<file_content>\n")
+		sb.WriteString(f.content)
+		sb.WriteString("\n</file_content>\n</project_guidance>\n")
+	}
+	if d.opts.Summary {
+		sb.WriteString("<context_summary>\n" + d.summary + "\n</context_summary>\n")
+	}
+	if r := d.repo; r != nil {
+		sb.WriteString("<repo_info>\n")
+		for _, f := range r.fields() {
+			sb.WriteString("<" + f[0] + ">" + f[1] + "</" + f[0] + ">\n")
+		}
+		sb.WriteString("</repo_info>\n")
+	}
+	if !d.opts.NoTree {
+		sb.WriteString("<file_tree>\n")
+		sb.WriteString(d.tree)
+		sb.WriteString("</file_tree>\n")
+	}
+	for _, f := range d.files {
+		sb.WriteString("<file>\n<file_path>" + f.path + "</file_path>\n")
+		if f.mode != "" {
+			sb.WriteString("<file_mode>" + f.mode + "</file_mode>\n")
+		}
+		if d.opts.FileTokens {
+			sb.WriteString("<file_tokens>" + strconv.Itoa(d.opts.countTokens(f.content)) + "</file_tokens>\n")
+		}
+		for _, n := range f.notes {
+			sb.WriteString("<note>" + n + "</note>\n")
+		}
+		sb.WriteString("<file_content>\n")
+		sb.WriteString(f.content)
+		sb.WriteString("\n</file_content>\n</file>\n")
+	}
+	for _, b := range d.bundles {
+		sb.WriteString("<directory_bundle>\n<directory_path>" + b.path + "</directory_path>\n<bundle_content>\n")
+		for _, f := range b.files {
+			sb.WriteString("--- file: " + f.path + " ---\n")
+			for _, n := range f.notes {
+				sb.WriteString("(" + n + ")\n")
+			}
+			sb.WriteString(f.content + "\n")
+		}
+		sb.WriteString("</bundle_content>\n</directory_bundle>\n")
+	}
+	for _, res := range d.commands {
+		sb.WriteString("<command_output>\n<command>" + res.command + "</command>\n")
+		switch {
+		case res.err != nil:
+			sb.WriteString("<error>" + res.err.Error() + "</error>\n")
+		case res.exitCode != 0:
+			sb.WriteString("<exit_code>" + strconv.Itoa(res.exitCode) + "</exit_code>\n")
+		}
+		sb.WriteString("<output>\n" + strings.TrimSuffix(res.output, "\n") + "\n</output>\n</command_output>\n")
+	}
+	if res := d.diff; res != nil {
+		sb.WriteString("<git_diff>\n<diff_base>" + res.base + "</diff_base>\n")
+		if res.staged {
+			sb.WriteString("<diff_scope>staged changes only</diff_scope>\n")
+		}
+		if res.err != nil {
+			sb.WriteString("<error>" + res.err.Error() + "</error>\n")
+		} else {
+			sb.WriteString("<diff_content>\n" + strings.TrimSuffix(res.diff, "\n") + "\n</diff_content>\n")
+		}
+		sb.WriteString("</git_diff>\n")
+	}
+	if d.commits != "" {
+		sb.WriteString("<recent_commits>\n" + d.commits + "\n</recent_commits>\n")
+	}
+	sb.WriteString("<user_request>\n" + d.request + "\n</user_request>")
+	return sb.String()
+}
+
+// renderMarkdown emits headings and fenced code blocks, which paste well
+// into chat UIs that render markdown.
+func renderMarkdown(d promptData) string {
+	var sb strings.Builder
+	if d.system != "" {
+		sb.WriteString("## Instructions\n\n" + d.system + "\n\n")
+	}
+	for _, f := range d.guidance {
+		sb.WriteString("## Project guidance: " + f.path + "\n\n")
+		sb.WriteString(codeBlock(f.content, langFor(f.path)))
+	}
+	if d.opts.Summary {
+		sb.WriteString("## Summary\n\n" + d.summary + "\n\n")
+	}
+	if r := d.repo; r != nil {
+		sb.WriteString("## Repository\n\n")
+		for _, f := range r.fields() {
+			sb.WriteString("- " + f[0] + ": " + f[1] + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	if !d.opts.NoTree {
+		sb.WriteString("## File tree\n\n")
+		sb.WriteString(codeBlock(strings.TrimSuffix(d.tree, "\n"), ""))
+	}
+	if len(d.files) > 0 {
+		sb.WriteString("## Files\n\n")
+	}
+	for _, f := range d.files {
+		sb.WriteString("### " + f.path + "\n\n")
+		var meta []string
+		if f.mode != "" {
+			meta = append(meta, "mode "+f.mode)
+		}
+		if d.opts.FileTokens {
+			meta = append(meta, "~"+strconv.Itoa(d.opts.countTokens(f.content))+" tokens")
+		}
+		if len(meta) > 0 {
+			sb.WriteString("_" + strings.Join(meta, ", ") + "_\n\n")
+		}
+		for _, n := range f.notes {
+			sb.WriteString("> " + n + "\n")
+		}
+		if len(f.notes) > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(codeBlock(f.content, langFor(f.path)))
+	}
+	for _, b := range d.bundles {
+		sb.WriteString("## Directory: " + b.path + "\n\n")
+		for _, f := range b.files {
+			sb.WriteString("#### " + f.path + "\n\n")
+			for _, n := range f.notes {
+				sb.WriteString("> " + n + "\n\n")
+			}
+			sb.WriteString(codeBlock(f.content, langFor(f.path)))
+		}
+	}
+	for _, res := range d.commands {
+		sb.WriteString("## Command: `" + res.command + "`\n\n")
+		switch {
+		case res.err != nil:
+			sb.WriteString("> Error: " + res.err.Error() + "\n\n")
+		case res.exitCode != 0:
+			sb.WriteString("> Exit code " + strconv.Itoa(res.exitCode) + "\n\n")
+		}
+		sb.WriteString(codeBlock(strings.TrimSuffix(res.output, "\n"), ""))
+	}
+	if res := d.diff; res != nil {
+		sb.WriteString("## Git diff against " + res.label() + "\n\n")
+		if res.err != nil {
+			sb.WriteString("> Error: " + res.err.Error() + "\n\n")
+		} else {
+			sb.WriteString(codeBlock(res.diff, "diff"))
+		}
+	}
+	if d.commits != "" {
+		sb.WriteString("## Recent commits\n\n" + codeBlock(d.commits, ""))
+	}
+	sb.WriteString("## Request\n\n" + d.request)
+	return sb.String()
+}
+
+// repomixRule and repomixFileRule are the separators of repomix's plain
+// style.
+var (
+	repomixRule     = strings.Repeat("=", 64)
+	repomixFileRule = strings.Repeat("=", 16)
+)
+
+// renderRepomix follows the layout of repomix's plain output, so prompts
+// written for packed repositories work unchanged: a summary header, the
+// directory structure, then each file between separators.
+func renderRepomix(d promptData) string {
+	var sb strings.Builder
+	section := func(title string) {
+		sb.WriteString(repomixRule + "\n" + title + "\n" + repomixRule + "\n")
+	}
+	file := func(path string, notes []string, content string) {
+		sb.WriteString("\n" + repomixFileRule + "\nFile: " + path + "\n" + repomixFileRule + "\n")
+		for _, n := range notes {
+			sb.WriteString("(" + n + ")\n")
+		}
+		sb.WriteString(strings.TrimSuffix(content, "\n") + "\n")
+	}
+	sb.WriteString("This file is a merged representation of a subset of the codebase, combined into a single document.\n\n")
+	section("File Summary")
+	sb.WriteString(`
+Purpose:
+--------
+This file contains a packed representation of the selected files of the
+repository. It is designed to be easily consumable by AI systems for
+analysis, code review, or other automated processes.
+
+File Format:
+------------
+The content is organized as follows:
+1. This summary section
+2. Directory structure
+3. Multiple file entries, each consisting of:
+  a. A separator line (================)
+  b. The file path (File: path/to/file)
+  c. Another separator line
+  d. The contents of the file
+  e. A blank line
+
+Notes:
+------
+- Only files selected by the user are included
+- Binary files are not included in their original form
+`)
+	if d.opts.Summary {
+		sb.WriteString("- Files by type: " + d.summary + "\n")
+	}
+	if r := d.repo; r != nil {
+		for _, f := range r.fields() {
+			sb.WriteString("- Repository " + f[0] + ": " + f[1] + "\n")
+		}
+	}
+	if d.system != "" {
+		sb.WriteString("\nUser Provided Header:\n-----------------------\n" + d.system + "\n")
+	}
+	sb.WriteString("\n")
+	if !d.opts.NoTree {
+		section("Directory Structure")
+		sb.WriteString(indentTree(d.tree) + "\n")
+	}
+	section("Files")
+	for _, f := range d.guidance {
+		file(f.path, f.notes, f.content)
+	}
+	for _, f := range d.files {
+		file(f.path, f.notes, f.content)
+	}
+	for _, b := range d.bundles {
+		for _, f := range b.files {
+			file(filepath.Join(b.path, f.path), f.notes, f.content)
+		}
+	}
+	for _, res := range d.commands {
+		sb.WriteString("\n")
+		section("Command: " + res.command)
+		switch {
+		case res.err != nil:
+			sb.WriteString("(error: " + res.err.Error() + ")\n")
+		case res.exitCode != 0:
+			sb.WriteString("(exit code " + strconv.Itoa(res.exitCode) + ")\n")
+		}
+		sb.WriteString(strings.TrimSuffix(res.output, "\n") + "\n")
+	}
+	if res := d.diff; res != nil {
+		sb.WriteString("\n")
+		section("Git Diff: " + res.label())
+		if res.err != nil {
+			sb.WriteString("(error: " + res.err.Error() + ")\n")
+		} else {
+			sb.WriteString(strings.TrimSuffix(res.diff, "\n") + "\n")
+		}
+	}
+	if d.commits != "" {
+		sb.WriteString("\n")
+		section("Recent Commits")
+		sb.WriteString(d.commits + "\n")
+	}
+	sb.WriteString("\n")
+	section("Instruction")
+	sb.WriteString(d.request)
+	return sb.String()
+}
+
+// indentTree redraws a box-drawn file tree the way repomix lists
+// directories: two spaces per level, with a slash after each directory
+// that has entries shown.
+func indentTree(tree string) string {
+	lines := strings.Split(strings.TrimSuffix(tree, "\n"), "\n")
+	depths := make([]int, len(lines))
+	names := make([]string, len(lines))
+	for i, line := range lines {
+		r := []rune(line)
+		// every level is four runes: "│   ", "    ", "├── " or "└── "
+		for len(r) >= 4 && strings.ContainsRune("│ ├└", r[0]) {
+			r = r[4:]
+			depths[i]++
+		}
+		names[i] = string(r)
+	}
+	var sb strings.Builder
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		if i+1 < len(lines) && depths[i+1] > depths[i] {
+			name += "/"
+		}
+		sb.WriteString(strings.Repeat("  ", max(depths[i]-1, 0)) + name + "\n")
+	}
+	return sb.String()
+}
+
+// jsonPrompt is the document --format json emits, and the data a
+// --template is executed with.
+type jsonPrompt struct {
+	System      string        `json:"system,omitempty"`
+	Guidance    []jsonFile    `json:"guidance,omitempty"`
+	Summary     string        `json:"summary,omitempty"`
+	Repo        *jsonRepo     `json:"repo,omitempty"`
+	Tree        string        `json:"tree,omitempty"`
+	Files       []jsonFile    `json:"files"`
+	Directories []jsonBundle  `json:"directories,omitempty"`
+	Commands    []jsonCommand `json:"commands,omitempty"`
+	Diff        *jsonDiff     `json:"diff,omitempty"`
+	Commits     string        `json:"recent_commits,omitempty"`
+	Request     string        `json:"request"`
+}
+
+type jsonFile struct {
+	Path      string   `json:"path"`
+	Size      int64    `json:"size"`
+	Mode      string   `json:"mode,omitempty"`
+	Language  string   `json:"language,omitempty"`
+	Tokens    int      `json:"tokens,omitempty"`
+	Binary    bool     `json:"binary,omitempty"`
+	Truncated bool     `json:"truncated"`
+	Notes     []string `json:"notes,omitempty"`
+	Content   string   `json:"content"`
+}
+
+type jsonBundle struct {
+	Path  string     `json:"path"`
+	Files []jsonFile `json:"files"`
+}
+
+type jsonCommand struct {
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	Output   string `json:"output"`
+}
+
+type jsonRepo struct {
+	Remote string `json:"remote,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Dirty  bool   `json:"dirty"`
+}
+
+type jsonDiff struct {
+	Base    string `json:"base"`
+	Staged  bool   `json:"staged,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Content string `json:"content"`
+}
+
+// renderJSON emits the prompt as one JSON document for scripts and agents.
+func renderJSON(d promptData) string {
+	doc := exportPrompt(d, d.opts.FileTokens)
+	if !d.opts.Summary {
+		doc.Summary = ""
+	}
+	if d.opts.NoTree {
+		doc.Tree = ""
+	}
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	// only strings and numbers go in, so encoding can't fail
+	enc.Encode(doc)
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// exportPrompt converts d to the exported form shared by --format json and
+// --template, counting each file's tokens if tokens is set.
+func exportPrompt(d promptData, tokens bool) jsonPrompt {
+	files := func(pfs []promptFile) []jsonFile {
+		out := make([]jsonFile, len(pfs))
+		for i, f := range pfs {
+			out[i] = jsonFile{Path: f.path, Size: f.size, Mode: f.mode, Language: langFor(f.path), Binary: !f.text, Truncated: f.truncated, Notes: f.notes, Content: f.content}
+			if tokens {
+				out[i].Tokens = d.opts.countTokens(f.content)
+			}
+		}
+		return out
+	}
+	doc := jsonPrompt{System: d.system, Guidance: files(d.guidance), Summary: d.summary, Tree: d.tree, Files: files(d.files), Request: d.request}
+	for _, b := range d.bundles {
+		doc.Directories = append(doc.Directories, jsonBundle{Path: b.path, Files: files(b.files)})
+	}
+	for _, res := range d.commands {
+		c := jsonCommand{Command: res.command, ExitCode: res.exitCode, Output: res.output}
+		if res.err != nil {
+			c.Error = res.err.Error()
+		}
+		doc.Commands = append(doc.Commands, c)
+	}
+	doc.Commits = d.commits
+	if r := d.repo; r != nil {
+		doc.Repo = &jsonRepo{Remote: r.remote, Branch: r.branch, Commit: r.commit, Dirty: r.dirty}
+	}
+	if res := d.diff; res != nil {
+		doc.Diff = &jsonDiff{Base: res.base, Staged: res.staged, Content: res.diff}
+		if res.err != nil {
+			doc.Diff.Error = res.err.Error()
+		}
+	}
+	return doc
+}
+
+// fields lists the known parts of r as name and value pairs, for the
+// text formats.
+func (r repoInfo) fields() [][2]string {
+	var fields [][2]string
+	for _, f := range [][2]string{{"remote", r.remote}, {"branch", r.branch}, {"commit", r.commit}} {
+		if f[1] != "" {
+			fields = append(fields, f)
+		}
+	}
+	return append(fields, [2]string{"dirty", strconv.FormatBool(r.dirty)})
+}
+
+// label names the diff's base for headings, e.g. "HEAD (staged)".
+func (r diffResult) label() string {
+	if r.staged {
+		return r.base + " (staged)"
+	}
+	return r.base
+}
+
+// codeBlock fences s, tagged with lang, followed by a blank line.
+func codeBlock(s, lang string) string {
+	s = strings.TrimSuffix(s, "\n")
+	f := fenceFor(s)
+	return f + lang + "\n" + s + "\n" + f + "\n\n"
+}
+
+// languages maps extensions and special file names to code block tags.
+var languages = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".mjs": "javascript",
+	".ts": "typescript", ".tsx": "tsx", ".jsx": "jsx", ".rs": "rust",
+	".rb": "ruby", ".java": "java", ".kt": "kotlin", ".swift": "swift",
+	".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp",
+	".cs": "csharp", ".php": "php", ".sh": "bash", ".bash": "bash",
+	".zsh": "zsh", ".sql": "sql", ".html": "html", ".css": "css",
+	".scss": "scss", ".json": "json", ".yaml": "yaml", ".yml": "yaml",
+	".toml": "toml", ".xml": "xml", ".md": "markdown", ".lua": "lua",
+	".proto": "protobuf", ".tf": "hcl", ".vue": "vue", ".svelte": "svelte",
+	"makefile": "makefile", "dockerfile": "dockerfile",
+}
+
+// langFor returns the code block tag for path, or "" if unknown.
+func langFor(path string) string {
+	if lang, ok := languages[strings.ToLower(filepath.Ext(path))]; ok {
+		return lang
+	}
+	return languages[strings.ToLower(filepath.Base(path))]
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type diffMarker byte
+
+const (
+	markAdded    diffMarker = '+'
+	markRemoved  diffMarker = '-'
+	markModified diffMarker = '~'
+)
+
+// gitTopLevel returns the top directory of the repository containing dir.
+func gitTopLevel(dir string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not inside a git repository", dir)
+	}
+	return filepath.FromSlash(strings.TrimSpace(string(out))), nil
+}
+
+// gitDiff returns the diff of the working tree under dir against base, a
+// commit such as HEAD or main, covering staged and unstaged changes to
+// tracked files, or with staged set, of the index against base.
+func gitDiff(dir, base string, staged bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	args := []string{"-C", dir, "diff", "--no-color", "--no-ext-diff"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	out, err := exec.CommandContext(ctx, "git", append(args, base, "--", ".")...).Output()
+	if err != nil {
+		return "", fmt.Errorf("git diff %s: %w", base, err)
+	}
+	return string(out), nil
+}
+
+// gitVerifyCommit checks that ref names a commit in the repository
+// containing dir.
+func gitVerifyCommit(dir, ref string) error {
+	if err := exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}").Run(); err != nil {
+		return fmt.Errorf("%q is not a commit in %s", ref, dir)
+	}
+	return nil
+}
+
+// gitChangedFiles lists the files under dir that differ from where the
+// current branch forked from ref: committed, staged and unstaged changes,
+// and untracked files that aren't ignored. Deleted files are left out.
+// Paths are absolute.
+func gitChangedFiles(dir, ref string) ([]string, error) {
+	top, err := gitTopLevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := exec.Command("git", "-C", dir, "diff", "--name-only", "-z", "--diff-filter=d", "--merge-base", ref, "--", ".").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff %s: %w", ref, err)
+	}
+	untracked, err := exec.Command("git", "-C", dir, "ls-files", "-z", "--full-name", "--others", "--exclude-standard", "--", ".").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files: %w", err)
+	}
+	return joinGitPaths(top, string(diff)+string(untracked)), nil
+}
+
+// gitStagedFiles lists the files under dir with changes in the index,
+// leaving out staged deletions. Paths are absolute.
+func gitStagedFiles(dir string) ([]string, error) {
+	top, err := gitTopLevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("git", "-C", dir, "diff", "--cached", "--name-only", "-z", "--diff-filter=d", "--", ".").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff --cached: %w", err)
+	}
+	return joinGitPaths(top, string(out)), nil
+}
+
+// joinGitPaths splits NUL-separated paths relative to the repository top
+// and makes them absolute.
+func joinGitPaths(top, out string) []string {
+	var files []string
+	for _, rel := range strings.Split(out, "\x00") {
+		if rel != "" {
+			files = append(files, filepath.Join(top, filepath.FromSlash(rel)))
+		}
+	}
+	return files
+}
+
+// gitLog returns the last n commits touching dir, one per line in the git
+// pretty format, with short dates.
+func gitLog(dir string, n int, format string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "log", "--no-color", "--date=short", "-n", strconv.Itoa(n), "--format="+format, "--", ".").Output()
+	if err != nil {
+		return "", fmt.Errorf("git log: %w", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// repoInfo describes the state of the repository a prompt was built from.
+type repoInfo struct {
+	remote string
+	branch string
+	commit string
+	dirty  bool
+}
+
+// gitRepoInfo reads the origin remote (or the first remote), the current
+// branch, the HEAD commit and whether tracked files have uncommitted
+// changes. Parts that don't apply, such as the branch of a detached HEAD,
+// are left empty.
+func gitRepoInfo(dir string) (repoInfo, error) {
+	if _, err := gitTopLevel(dir); err != nil {
+		return repoInfo{}, err
+	}
+	git := func(args ...string) string {
+		out, _ := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		return strings.TrimSpace(string(out))
+	}
+	var info repoInfo
+	remote := "origin"
+	if remotes := strings.Fields(git("remote")); len(remotes) > 0 && !slices.Contains(remotes, remote) {
+		remote = remotes[0]
+	}
+	info.remote = redactRemote(git("remote", "get-url", remote))
+	info.branch = git("symbolic-ref", "--short", "-q", "HEAD")
+	info.commit = git("rev-parse", "-q", "--verify", "HEAD")
+	info.dirty = git("status", "--porcelain", "--untracked-files=no") != ""
+	return info, nil
+}
+
+// redactRemote drops any credentials embedded in a remote URL.
+func redactRemote(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil || u.Scheme == "" {
+		return remote
+	}
+	if _, ok := u.User.Password(); ok || u.Scheme == "https" || u.Scheme == "http" {
+		u.User = nil
+	}
+	return u.String()
+}
+
+// gitDiffMarkers diffs path against the git index and returns markers keyed
+// by 1-based line number in the working copy. Untracked files and paths
+// outside a repository yield no markers.
+func gitDiffMarkers(path string) map[int]diffMarker {
+	cmd := exec.Command("git", "-C", filepath.Dir(path), "diff", "--no-color", "--no-ext-diff", "-U0", "--", filepath.Base(path))
+	out, err := cmd.Output()
+	if err != nil || len(out) == 0 {
+		return nil
+	}
+	markers := map[int]diffMarker{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, "@@ ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		_, oldCount := parseHunkRange(fields[1])
+		newStart, newCount := parseHunkRange(fields[2])
+		switch {
+		case newCount == 0:
+			markers[max(newStart, 1)] = markRemoved
+		case oldCount == 0:
+			for l := newStart; l < newStart+newCount; l++ {
+				markers[l] = markAdded
+			}
+		default:
+			for l := newStart; l < newStart+newCount; l++ {
+				markers[l] = markModified
+			}
+		}
+	}
+	return markers
+}
+
+// parseHunkRange parses "-a,b" or "+c,d" from a hunk header; a missing
+// count means one line.
+func parseHunkRange(s string) (start, count int) {
+	s = strings.TrimLeft(s, "+-")
+	startStr, countStr, found := strings.Cut(s, ",")
+	start, _ = strconv.Atoi(startStr)
+	count = 1
+	if found {
+		count, _ = strconv.Atoi(countStr)
+	}
+	return start, count
+}
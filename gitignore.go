@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// gitignores reads the .gitignore files of the tree on demand. Each
+// repository in the tree, including submodules and other nested
+// repositories, is governed by its own .gitignore files only; rules of
+// the repository around it stop at its boundary. Files above the root
+// aren't read.
+type gitignores struct {
+	mu    sync.Mutex
+	rules map[string]ignoreRules
+	repos map[string]bool
+}
+
+func newGitignores() *gitignores {
+	return &gitignores{rules: map[string]ignoreRules{}, repos: map[string]bool{}}
+}
+
+// ignored reports whether p, under root, is ignored by the .gitignore
+// files of the repository it belongs to. matched is false if no rule
+// applies.
+func (g *gitignores) ignored(root, p string, isDir bool) (ignored, matched bool) {
+	if g == nil {
+		return false, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// the directories whose .gitignore applies, innermost first
+	var dirs []string
+	for dir := filepath.Dir(p); isWithin(root, dir); dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == root || g.repo(dir) {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(dirs[i], p)
+		if err != nil {
+			continue
+		}
+		if ign, ok := g.load(dirs[i]).match(filepath.ToSlash(rel), isDir); ok {
+			ignored, matched = ign, true
+		}
+	}
+	return ignored, matched
+}
+
+// repo reports whether dir is the top of a repository: it holds a .git
+// directory, or for submodules and worktrees a .git file.
+func (g *gitignores) repo(dir string) bool {
+	is, ok := g.repos[dir]
+	if !ok {
+		_, err := os.Lstat(filepath.Join(dir, ".git"))
+		is = err == nil
+		g.repos[dir] = is
+	}
+	return is
+}
+
+func (g *gitignores) load(dir string) ignoreRules {
+	rules, ok := g.rules[dir]
+	if !ok {
+		// an unreadable .gitignore is treated as empty
+		rules, _ = loadIgnore(filepath.Join(dir, ".gitignore"))
+		g.rules[dir] = rules
+	}
+	return rules
+}
+
+// forget drops what is cached about dir, e.g. after its .gitignore
+// changes.
+func (g *gitignores) forget(dir string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.rules, dir)
+	delete(g.repos, dir)
+}
+
+// reset forgets everything cached, e.g. on a refresh.
+func (g *gitignores) reset() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	clear(g.rules)
+	clear(g.repos)
+}
+
+// repoKind describes a directory that starts a nested repository: a
+// submodule (whose .git is a file) or a repository cloned inside the tree.
+// It is empty for other directories.
+func repoKind(dir string) string {
+	info, err := os.Lstat(filepath.Join(dir, ".git"))
+	switch {
+	case err != nil:
+		return ""
+	case info.IsDir():
+		return "repo"
+	}
+	return "submodule"
+}
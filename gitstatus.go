@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/fsnotify/fsnotify"
+)
+
+// gitState is a file's status in git, as shown by its badge in the tree.
+type gitState byte
+
+const (
+	gitModified  gitState = 'M'
+	gitStaged    gitState = 'S'
+	gitUntracked gitState = '?'
+	gitIgnored   gitState = '!'
+	gitConflict  gitState = 'U'
+)
+
+// gitStatusDebounce is how long file changes must settle before the status
+// is refreshed.
+const gitStatusDebounce = 500 * time.Millisecond
+
+type gitStatusTickMsg struct{ seq int }
+
+type gitStatusMsg struct {
+	seq    int
+	states map[string]gitState
+}
+
+// loadGitStatus returns the git state of each changed, untracked or
+// ignored path in the repository containing root, keyed by absolute path.
+// Unmodified files are left out; outside a repository it returns nil.
+// Working tree changes win over staged ones when a file has both.
+func loadGitStatus(root string) map[string]gitState {
+	top, err := gitTopLevel(root)
+	if err != nil {
+		return nil
+	}
+	out, err := exec.Command("git", "-C", top, "status", "--porcelain=v1", "-z", "--untracked-files=all", "--ignored=matching").Output()
+	if err != nil {
+		return nil
+	}
+	states := map[string]gitState{}
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		if len(e) < 4 {
+			continue
+		}
+		x, y, rel := e[0], e[1], e[3:]
+		if x == 'R' || x == 'C' {
+			// the source path follows as its own entry
+			i++
+		}
+		var st gitState
+		switch {
+		case x == '?':
+			st = gitUntracked
+		case x == '!':
+			st = gitIgnored
+		case x == 'U' || y == 'U' || x == 'A' && y == 'A' || x == 'D' && y == 'D':
+			st = gitConflict
+		case y != ' ':
+			st = gitModified
+		default:
+			st = gitStaged
+		}
+		states[filepath.Join(top, filepath.FromSlash(strings.TrimSuffix(rel, "/")))] = st
+	}
+	return states
+}
+
+// gitStatusCmd reads the status of the repository containing root in the
+// background; seq discards results overtaken by a newer refresh.
+func gitStatusCmd(seq int, root string) tea.Cmd {
+	return func() tea.Msg {
+		return gitStatusMsg{seq: seq, states: loadGitStatus(root)}
+	}
+}
+
+func (m *model) refreshGitStatus() tea.Cmd {
+	if m.root == nil {
+		return nil
+	}
+	m.gitSeq++
+	return gitStatusCmd(m.gitSeq, m.root.path)
+}
+
+// scheduleGitStatus refreshes the status once changes stop arriving.
+func (m *model) scheduleGitStatus() tea.Cmd {
+	m.gitSeq++
+	seq := m.gitSeq
+	return tea.Tick(gitStatusDebounce, func(time.Time) tea.Msg { return gitStatusTickMsg{seq: seq} })
+}
+
+// gitBadge renders n's git state for the tree, or nothing outside a
+// repository. Directories show a dot when something beneath them changed,
+// and entries inside an ignored directory show as ignored.
+func gitBadge(states map[string]gitState, n *node) string {
+	if states == nil {
+		return ""
+	}
+	if st, ok := states[n.path]; ok {
+		return gitStyle(st).Render(string(st)) + " "
+	}
+	for p := n.parent; p != nil; p = p.parent {
+		if states[p.path] == gitIgnored {
+			return gitStyle(gitIgnored).Render(string(gitIgnored)) + " "
+		}
+	}
+	if n.isDir {
+		prefix := n.path + string(filepath.Separator)
+		for p, st := range states {
+			if st != gitIgnored && strings.HasPrefix(p, prefix) {
+				return ui.gitModified.Render("•") + " "
+			}
+		}
+	}
+	return "  "
+}
+
+func gitStyle(st gitState) lipgloss.Style {
+	switch st {
+	case gitModified:
+		return ui.gitModified
+	case gitStaged:
+		return ui.gitStaged
+	case gitUntracked:
+		return ui.gitUntracked
+	case gitConflict:
+		return ui.warning
+	}
+	return ui.blurred
+}
+
+// selectGitChanged selects every modified, staged, untracked or conflicted
+// file under root, loading and expanding the directories leading to them.
+// It returns the fresh status and the number of files selected.
+func selectGitChanged(root *node, watcher *fsnotify.Watcher) (map[string]gitState, int) {
+	states := loadGitStatus(root.path)
+	var paths []string
+	for p, st := range states {
+		if st != gitIgnored {
+			paths = append(paths, p)
+		}
+	}
+	return states, selectFiles(root, paths, watcher)
+}
+
+// selectFiles selects the files of paths that the tree under root shows,
+// loading and expanding the directories leading to them. It returns the
+// number of files selected.
+func selectFiles(root *node, paths []string, watcher *fsnotify.Watcher) int {
+	paths = slices.Sorted(slices.Values(paths))
+	count := 0
+	for _, p := range paths {
+		if ignoredPath(root, p, false) {
+			continue
+		}
+		if n := lookupPath(root, p, watcher, true); n != nil && !n.isDir && !n.ignored {
+			n.selected = true
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// matchGlob reports whether the slash-separated path rel matches pattern.
+// Segments follow path.Match, and a "**" segment matches any number of
+// directories, including none.
+func matchGlob(pattern, rel string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// selectGlobs selects every file under root whose relative path matches
+// one of patterns, loading directories as needed without expanding them.
+// It returns the number of files selected.
+func selectGlobs(root *node, patterns []string, watcher *fsnotify.Watcher) int {
+	if len(patterns) == 0 {
+		return 0
+	}
+	count := 0
+	walkFiles(root, root.path, func(p string) error {
+		rel, err := filepath.Rel(root.path, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		for _, pat := range patterns {
+			if !matchGlob(pat, rel) {
+				continue
+			}
+			if n := lookupPath(root, p, watcher, false); n != nil {
+				n.selected = true
+				count++
+			}
+			break
+		}
+		return nil
+	})
+	return count
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ignoreFileName is the project's list of paths to leave out of the
+// picker, in gitignore syntax. It is read from the root and applies
+// whether or not the project uses git.
+const ignoreFileName = ".ctxignore"
+
+// ignoreRule is one pattern of an ignore file.
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are the rules of an ignore file in order; the last rule
+// matching a path decides whether it is ignored.
+type ignoreRules []ignoreRule
+
+// loadIgnore reads the ignore file at path; a missing file has no rules.
+func loadIgnore(path string) (ignoreRules, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules ignoreRules
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if r, ok := parseIgnoreLine(sc.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, sc.Err()
+}
+
+// parseIgnoreLine parses a gitignore line. A pattern without a slash
+// matches a name at any depth; one with a slash is relative to the root.
+// "!" re-includes, a trailing "/" matches only directories, and "#" starts
+// a comment unless escaped.
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	var r ignoreRule
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	r.pattern = line
+	return r, true
+}
+
+// ignored reports whether the slash-separated path rel is ignored. The
+// contents of an ignored directory are never looked at, so they can't be
+// re-included, as with git.
+func (rs ignoreRules) ignored(rel string, isDir bool) bool {
+	ignored, _ := rs.match(rel, isDir)
+	return ignored
+}
+
+// match is like ignored but also reports whether any rule matched, so a
+// later set of rules can override an earlier one.
+func (rs ignoreRules) match(rel string, isDir bool) (ignored, matched bool) {
+	for _, r := range rs {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if matchGlob(r.pattern, rel) {
+			ignored, matched = !r.negate, true
+		}
+	}
+	return ignored, matched
+}
+
+// defaultExcludes are dependency, build and tool directories left out of
+// the tree unless --no-default-excludes is given. They come before the
+// .ctxignore rules, which can re-include one with e.g. "!vendor/".
+var defaultExcludes = []string{
+	".git/", "node_modules/", "bower_components/", "vendor/", "target/",
+	"dist/", "build/", "out/", ".next/", ".nuxt/", ".svelte-kit/",
+	"__pycache__/", ".venv/", "venv/", ".tox/", ".mypy_cache/",
+	".pytest_cache/", ".ruff_cache/", "*.egg-info/", ".gradle/", ".idea/",
+	".vscode/", ".terraform/", ".cache/", "coverage/", ".DS_Store",
+}
+
+// includeGlobs turns --include patterns into globs for matchGlob; as in
+// ignore files, a pattern without a slash matches a name at any depth.
+func includeGlobs(patterns []string) []string {
+	globs := make([]string, len(patterns))
+	for i, p := range patterns {
+		if strings.Contains(p, "/") {
+			globs[i] = strings.TrimPrefix(p, "/")
+		} else {
+			globs[i] = "**/" + p
+		}
+	}
+	return globs
+}
+
+// excludeRules turns --exclude patterns into ignore rules.
+func excludeRules(patterns []string) ignoreRules {
+	var rules ignoreRules
+	for _, p := range patterns {
+		if r, ok := parseIgnoreLine(p); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// ignoredPath reports whether p, under the tree's root, is left out by
+// .gitignore files or the root's ignore rules, which can re-include what
+// git ignores, or is a file matching none of its include globs or missing
+// from its only set.
+func ignoredPath(root *node, p string, isDir bool) bool {
+	if len(root.ignore) == 0 && len(root.include) == 0 && root.only == nil && root.gitignore == nil {
+		return false
+	}
+	rel, err := filepath.Rel(root.path, p)
+	if err != nil || rel == "." {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	ignored, _ := root.gitignore.ignored(root.path, p, isDir)
+	if ign, ok := root.ignore.match(rel, isDir); ok {
+		ignored = ign
+	}
+	if ignored {
+		return true
+	}
+	if isDir {
+		return false
+	}
+	if root.only != nil && !root.only[p] {
+		return true
+	}
+	if len(root.include) == 0 {
+		return false
+	}
+	for _, g := range root.include {
+		if matchGlob(g, rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// treeRoot returns the root of the tree n belongs to.
+func (n *node) treeRoot() *node {
+	for n.parent != nil {
+		n = n.parent
+	}
+	return n
+}
+
+// walkFiles calls fn for each file under dir, in lexical order, skipping
+// .git and whatever root's ignore rules leave out of the tree. fn may
+// return filepath.SkipAll to stop.
+func walkFiles(root *node, dir string, fn func(p string) error) {
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return nil
+		case d.IsDir() && p == dir:
+			return nil
+		case d.IsDir() && (d.Name() == ".git" || ignoredPath(root, p, true)):
+			return filepath.SkipDir
+		case d.IsDir() || ignoredPath(root, p, false):
+			return nil
+		}
+		return fn(p)
+	})
+}
+
+// reloadLoaded reloads every loaded directory under n, e.g. after the
+// ignore display changes, keeping existing nodes.
+func reloadLoaded(n *node, watcher *fsnotify.Watcher) {
+	if !n.childrenLoaded {
+		return
+	}
+	loadChildren(n, watcher)
+	for _, c := range n.children {
+		reloadLoaded(c, watcher)
+	}
+}
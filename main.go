@@ -1,34 +1,58 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/fsnotify/fsnotify"
+	"github.com/mattn/go-runewidth"
 )
 
 var (
-	focusedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
-	blurredStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+	focusedStyle  = ui.focused
+	blurredStyle  = ui.blurred
 	focusedButton = focusedStyle.Render("[ Copy ]")
 	blurredButton = blurredStyle.Render("[ Copy ]")
+	warningStyle  = ui.warning
+	cursorStyle   = ui.cursor
+	markedStyle   = ui.marked
+	titleStyle    = ui.title
 )
 
+// clearConfirmThreshold is the selection size above which clearing it
+// asks for confirmation.
+const clearConfirmThreshold = 5
+
+const minTextareaHeight = 3
+
+// resizeDebounce is how long the window size must hold before the panes
+// are laid out again.
+const resizeDebounce = 30 * time.Millisecond
+
+// resizeMsg applies a window size once no newer resize has arrived.
+type resizeMsg struct {
+	seq           int
+	width, height int
+}
+
 type sessionState uint
 
 const (
 	fileTreeView = iota
 	textAreaView
 	acceptView
+	responseView
+	patchView
 )
 
 type node struct {
@@ -39,13 +63,92 @@ type node struct {
 	selected       bool
 	parent         *node
 	childrenLoaded bool
+	size           int64
+	lines          lineRange
+	emptyKnown     bool
+	empty          bool
+	// shown is how many children are listed when the directory is paged.
+	shown int
+	// marked includes a directory's structure in the tree without
+	// selecting its files.
+	marked bool
+	pinned bool
+	// included marks a file that was in the last prompt reviewed.
+	included bool
+	// ignore and include filter the root's tree: the rules of .ctxignore
+	// and --exclude, and the --include globs files must match. When only is
+	// set, files not in it are left out too.
+	ignore  ignoreRules
+	include []string
+	only    map[string]bool
+	// gitignore applies the tree's .gitignore files; nil with
+	// --no-gitignore.
+	gitignore *gitignores
+	// repo is "submodule" or "repo" for a directory that starts a nested
+	// repository.
+	repo string
+	// showIgnored keeps ignored entries in the root's tree, flagged with
+	// ignored so they are drawn dimmed and can't be selected.
+	showIgnored bool
+	ignored     bool
+}
+
+// lineRange is an inclusive, 1-based span of lines to emit for a file. The
+// zero value means the whole file.
+type lineRange struct {
+	start, end int
+}
+
+func (r lineRange) contains(line int) bool {
+	return r.start > 0 && line >= r.start && line <= r.end
+}
+
+// clearSelection deselects and unmarks n and everything beneath it.
+func (n *node) clearSelection() {
+	n.selected = false
+	n.marked = false
+	for _, c := range n.children {
+		c.clearSelection()
+	}
+}
+
+// markIncluded flags the selected files as included in the prompt just
+// generated, replacing earlier flags.
+func (n *node) markIncluded() {
+	n.included = n.selected && !n.isDir
+	for _, c := range n.children {
+		c.markIncluded()
+	}
+}
+
+func (n *node) clearIncluded() {
+	n.included = false
+	for _, c := range n.children {
+		c.clearIncluded()
+	}
+}
+
+// keepsIncluded reports whether any file of the last prompt is still
+// selected; once none is, the selection is a fresh one.
+func (n *node) keepsIncluded() bool {
+	if n.included && n.selected {
+		return true
+	}
+	for _, c := range n.children {
+		if c.keepsIncluded() {
+			return true
+		}
+	}
+	return false
 }
 
 func (n *node) toggleSelect(on bool) {
 	n.selected = on
 	if n.isDir {
 		for _, c := range n.children {
-			c.toggleSelect(on)
+			if !c.ignored {
+				c.toggleSelect(on)
+			}
 		}
 	}
 }
@@ -55,33 +158,148 @@ func loadChildren(n *node, watcher *fsnotify.Watcher) {
 	if err != nil {
 		return
 	}
+	// keep existing nodes so reloads preserve selection and expansion
+	existing := make(map[string]*node, len(n.children))
+	for _, c := range n.children {
+		existing[c.path] = c
+	}
 	n.children = nil
+	root := n.treeRoot()
 	for _, f := range files {
 		childPath := filepath.Join(n.path, f.Name())
-		child := &node{
-			path:   childPath,
-			isDir:  f.IsDir(),
-			parent: n,
+		ignored := n.ignored || ignoredPath(root, childPath, f.IsDir())
+		if ignored && !root.showIgnored {
+			continue
 		}
-		n.children = append(n.children, child)
+		child, ok := existing[childPath]
+		if !ok || child.isDir != f.IsDir() {
+			child = &node{
+				path:   childPath,
+				isDir:  f.IsDir(),
+				parent: n,
+			}
+			if n.selected && !ignored {
+				child.toggleSelect(true)
+			}
+		}
+		child.ignored = ignored
 		if child.isDir {
+			child.repo = repoKind(childPath)
+		}
+		if info, err := f.Info(); err == nil {
+			child.size = info.Size()
+		}
+		n.children = append(n.children, child)
+		if child.isDir && watcher != nil {
 			watcher.Add(childPath)
 		}
 	}
 	n.childrenLoaded = true
 }
 
+// isEmptyDir reports whether n is a directory with no files anywhere
+// beneath it. The answer is cached on the node until invalidated.
+func isEmptyDir(n *node) bool {
+	if !n.isDir {
+		return false
+	}
+	if !n.emptyKnown {
+		n.empty = !containsFile(n.treeRoot(), n.path)
+		n.emptyKnown = true
+	}
+	return n.empty
+}
+
+// containsFile reports whether dir holds a file that root's tree shows,
+// at any depth.
+func containsFile(root *node, dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		if ignoredPath(root, p, e.IsDir()) {
+			continue
+		}
+		if !e.IsDir() || containsFile(root, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidateEmpty forgets the cached emptiness of n and its ancestors.
+func invalidateEmpty(n *node) {
+	for ; n != nil; n = n.parent {
+		n.emptyKnown = false
+	}
+}
+
+// refreshTree re-reads every loaded directory under n from disk.
+func refreshTree(n *node, watcher *fsnotify.Watcher) {
+	if !n.isDir {
+		return
+	}
+	n.emptyKnown = false
+	if !n.childrenLoaded {
+		return
+	}
+	loadChildren(n, watcher)
+	for _, c := range n.children {
+		refreshTree(c, watcher)
+	}
+}
+
 type item struct {
 	node  *node
 	depth int
+	// more marks the "show more" entry that ends a paged directory.
+	more bool
+	// label replaces the indented base name, e.g. with a relative path.
+	label string
+	// pinned marks an entry of the pinned section above the tree.
+	pinned bool
 }
 
 func (i item) Title() string       { return filepath.Base(i.node.path) }
 func (i item) Description() string { return i.node.path }
-func (i item) FilterValue() string { return filepath.Base(i.node.path) }
+
+func (i item) FilterValue() string {
+	switch {
+	case i.more:
+		return ""
+	case i.label != "":
+		return i.label
+	}
+	return filepath.Base(i.node.path)
+}
 
 type customDelegate struct {
 	list.DefaultDelegate
+	sizeBars bool
+	maxSize  int64
+	page     int
+	// treeTokens shows each file's token count from tokens.
+	treeTokens bool
+	tokens     map[string]fileTokens
+	// maxSizeFor is the --max-file-size limit for a path; larger files are
+	// marked as they will be truncated.
+	maxSizeFor func(string) int64
+	// gitStates holds the git status of changed paths; nil outside a
+	// repository.
+	gitStates map[string]gitState
+}
+
+const sizeBarWidth = 8
+
+// sizeBar renders size as a bar proportional to max.
+func sizeBar(size, max int64) string {
+	filled := 0
+	if max > 0 {
+		filled = int((size*sizeBarWidth + max - 1) / max)
+	}
+	return " " + strings.Repeat("█", filled) + strings.Repeat("░", sizeBarWidth-filled)
 }
 
 func (d customDelegate) Render(w io.Writer, lm list.Model, index int, listItem list.Item) {
@@ -90,6 +308,17 @@ func (d customDelegate) Render(w io.Writer, lm list.Model, index int, listItem l
 		return
 	}
 
+	if i.more {
+		remaining := len(i.node.children) - max(i.node.shown, d.page)
+		str := strings.Repeat("  ", i.depth) + fmt.Sprintf("… %d more (enter to show)", remaining)
+		style := blurredStyle
+		if index == lm.Index() {
+			style = cursorStyle
+		}
+		fmt.Fprint(w, style.Render(runewidth.Truncate(str, max(lm.Width(), 0), "…")))
+		return
+	}
+
 	name := filepath.Base(i.node.path)
 	prefix := strings.Repeat("  ", i.depth)
 	var symbol string
@@ -102,24 +331,90 @@ func (d customDelegate) Render(w io.Writer, lm list.Model, index int, listItem l
 	} else {
 		symbol = "📄 "
 	}
-	str := prefix + symbol + name
+	if i.label != "" {
+		name = i.label
+	}
+	if i.node.repo != "" {
+		symbol = "📦 "
+		name += " [" + i.node.repo + "]"
+	}
+	switch {
+	case i.pinned:
+		symbol = "📌 "
+	case i.node.pinned:
+		name += " 📌"
+	}
+	if i.node.included {
+		// in the last prompt reviewed
+		name += " •"
+	}
+	if !i.node.isDir && d.maxSizeFor != nil {
+		if limit := d.maxSizeFor(i.node.path); limit > 0 && i.node.size > limit {
+			name += " ✂"
+		}
+	}
+	str := prefix + symbol + sanitizeName(name)
+
+	var suffix string
+	if d.sizeBars {
+		if i.node.isDir {
+			suffix = strings.Repeat(" ", sizeBarWidth+1)
+		} else {
+			suffix = blurredStyle.Render(sizeBar(i.node.size, d.maxSize))
+		}
+	}
+	if d.treeTokens {
+		if i.node.isDir {
+			suffix += strings.Repeat(" ", 7)
+		} else {
+			suffix += blurredStyle.Render(d.tokens[i.node.path].label())
+		}
+	}
+	suffix += gitBadge(d.gitStates, i.node)
+	if lipgloss.Width(suffix) >= lm.Width()-3 {
+		// too narrow for the extras; keep the name
+		suffix = ""
+	}
+	// pad/truncate by display width so wide runes don't push the checkbox
+	width := max(lm.Width()-3-lipgloss.Width(suffix), 0)
+	str = runewidth.FillRight(runewidth.Truncate(str, width, "…"), width)
 
 	var checkbox string
-	if i.node.selected {
+	checkboxStyle := lipgloss.NewStyle().Width(3)
+	switch {
+	case i.node.selected:
 		checkbox = "[x]"
-	} else {
+	case i.node.marked:
+		// structure only: the directory is drawn in the tree, its files aren't emitted
+		checkbox = "[~]"
+		checkboxStyle = markedStyle.Width(3)
+	default:
 		checkbox = "[ ]"
 	}
-	checkboxStyle := lipgloss.NewStyle().Width(3)
-	checkboxStr := checkboxStyle.Render(checkbox)
-
-	listItemStyle := lipgloss.NewStyle().Width(lm.Width() - 3)
+	listItemStyle := lipgloss.NewStyle()
+	if i.node.ignored {
+		checkbox = "[-]"
+		checkboxStyle = blurredStyle.Width(3)
+		listItemStyle = blurredStyle
+	}
 	if index == lm.Index() {
-		listItemStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("170")).Inherit(listItemStyle)
+		listItemStyle = cursorStyle
 	}
+	checkboxStr := checkboxStyle.Render(checkbox)
 	listItemStr := listItemStyle.Render(str)
 
-	fmt.Fprint(w, lipgloss.JoinHorizontal(lipgloss.Center, listItemStr, checkboxStr))
+	fmt.Fprint(w, lipgloss.JoinHorizontal(lipgloss.Center, listItemStr, suffix, checkboxStr))
+}
+
+// sanitizeName replaces control characters, such as newlines, that would
+// otherwise split a row across lines.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '?'
+		}
+		return r
+	}, name)
 }
 
 type (
@@ -129,7 +424,9 @@ type (
 
 type model struct {
 	list      list.Model
+	delegate  customDelegate
 	textarea  textarea.Model
+	viewport  viewport.Model
 	watcher   *fsnotify.Watcher
 	root      *node
 	flatItems []list.Item
@@ -139,27 +436,115 @@ type model struct {
 	width     int
 	height    int
 	quitting  bool
+	opts      options
+
+	caseSensitive bool
+	hideEmpty     bool
+	showHidden    bool
+	selectedView  bool
+	search        searchState
+	extFilter     extFilter
+	focusMode     bool
+	showPreview   bool
+	previewPath   string
+	previewText   bool
+	previewLines  int
+	rangeAnchor   int
+	rangeCursor   int
+	control       *control
+	warning       string
+	copyPrompt    bool
+	confirm       *confirmation
+	statuses      []statusEntry
+	statusSeq     int
+	resizeSeq     int
+	// pins are the pinned paths relative to the root, in pin order.
+	pins []string
+	nav  navHistory
+	// hints shows the main keys of the focused pane in the footer.
+	hints bool
+	// reviewSections index the file blocks of the prompt review.
+	reviewSections []reviewSection
+	reviewCursor   int
+	// chunks is the prompt split for paged copying; chunkIndex is the
+	// number already copied in this round.
+	chunks     []string
+	chunkIndex int
+	tokens     tokenState
+	// fileTokens caches per-file counts for the tree; the delegate shares
+	// the map.
+	fileTokens map[string]fileTokens
+	// diffMarkers caches the preview's git diff markers by path.
+	diffMarkers map[string]diffMarkers
+	// gitSeq numbers git status refreshes so stale results are dropped.
+	gitSeq int
+	// commands caches the output of the --cmd commands for the review.
+	commands commandCache
+	// response is the answer to the last prompt sent with s.
+	response response
+	// followUp is set while a follow-up question is written in the
+	// request pane.
+	followUp *followUp
+	// patch holds the diffs from the answer while they are previewed.
+	patch patchReview
+	// question is an open one-line prompt, such as the model picker.
+	question question
 }
 
-func newModel(path string) model {
-	abspath, err := filepath.Abs(path)
+// confirmation is a pending yes/no question shown in the footer.
+type confirmation struct {
+	prompt string
+	accept func(m *model) tea.Cmd
+}
+
+func newModel(opts options) model {
+	abspath, err := filepath.Abs(opts.Path)
 	if err != nil {
 		return model{
-			err: err,
+			err:  err,
+			opts: opts,
+		}
+	}
+	var watcher *fsnotify.Watcher
+	if !opts.NoWatch {
+		watcher, err = fsnotify.NewWatcher()
+		if err == nil {
+			watcher.Add(abspath)
+		}
+	}
+	ignore, ignoreErr := loadIgnore(filepath.Join(abspath, ignoreFileName))
+	var rules ignoreRules
+	if !opts.NoDefaultExcludes {
+		rules = excludeRules(defaultExcludes)
+	}
+	rules = append(append(rules, ignore...), excludeRules(opts.Exclude)...)
+	root := &node{path: abspath, isDir: true, expanded: true, ignore: rules, include: includeGlobs(opts.Include), showIgnored: opts.ShowIgnored}
+	// --staged and --changed-since narrow the tree to the files they list
+	if !opts.NoGitignore {
+		root.gitignore = newGitignores()
+	}
+	var changed []string
+	var changedErr error
+	switch {
+	case opts.Staged:
+		changed, changedErr = gitStagedFiles(abspath)
+	case opts.ChangedSince != "":
+		changed, changedErr = gitChangedFiles(abspath, opts.ChangedSince)
+	}
+	if opts.Staged || opts.ChangedSince != "" {
+		root.only = map[string]bool{}
+		for _, p := range changed {
+			root.only[p] = true
 		}
 	}
-	watcher, err := fsnotify.NewWatcher()
-	root := &node{path: abspath, isDir: true, expanded: true}
-	watcher.Add(abspath)
 	loadChildren(root, watcher)
-	flat := flatten(root)
 	ld := list.NewDefaultDelegate()
 	ld.SetSpacing(0)
 	ld.SetHeight(1)
 	ld.ShowDescription = false
-	d := customDelegate{ld}
-	l := list.New(flat, d, 0, 0)
-	l.Title = "File Tree"
+	fileTokens := map[string]fileTokens{}
+	d := customDelegate{DefaultDelegate: ld, sizeBars: opts.SizeBars, page: opts.MaxDirEntries, treeTokens: opts.TreeTokens, tokens: fileTokens, maxSizeFor: opts.maxSizeFor}
+	l := list.New(nil, d, 0, 0)
 	l.SetShowStatusBar(false)
 	l.SetFilteringEnabled(true)
 	l.SetShowHelp(false)
@@ -167,119 +552,820 @@ func newModel(path string) model {
 	ta := textarea.New()
 	ta.Placeholder = "Enter your task here..."
 	ta.CharLimit = 0
-	return model{
-		list:      l,
-		textarea:  ta,
-		watcher:   watcher,
-		root:      root,
-		flatItems: flat,
-		focus:     fileTreeView,
-		err:       err,
+	m := model{
+		list:          l,
+		delegate:      d,
+		textarea:      ta,
+		viewport:      viewport.New(0, 0),
+		watcher:       watcher,
+		root:          root,
+		focus:         fileTreeView,
+		err:           err,
+		opts:          opts,
+		caseSensitive: opts.CaseSensitive,
+		hideEmpty:     opts.HideEmpty,
+		showHidden:    opts.Hidden,
+		hints:         opts.Hints,
+		fileTokens:    fileTokens,
+		diffMarkers:   map[string]diffMarkers{},
 	}
+	if ignoreErr != nil {
+		m.warning = ignoreFileName + ": " + ignoreErr.Error()
+	}
+	if opts.Control != "" {
+		m.control, err = newControl(opts.Control)
+		if err != nil {
+			m.warning = "control: " + err.Error()
+		}
+	}
+	m.applyPins(loadPins(abspath))
+	m.applyFilterMode()
+	if !opts.NoAutoSelect {
+		selectGlobs(root, opts.Select, watcher)
+	}
+	switch {
+	case changedErr != nil:
+		m.warning = "git: " + changedErr.Error()
+	case opts.Staged && len(changed) == 0:
+		m.warning = "No staged changes"
+	case opts.ChangedSince != "" && len(changed) == 0:
+		m.warning = "No files changed since " + opts.ChangedSince
+	default:
+		selectFiles(root, changed, watcher)
+	}
+	m.reflatten()
+	if opts.Recipe != "" {
+		m.loadRecipe(opts.Recipe)
+	} else if opts.FromManifest != "" {
+		m.loadManifest(opts.FromManifest)
+	} else if rec, ok := loadSavedSelection(abspath); ok && root.only == nil {
+		m.confirm = &confirmation{
+			prompt: fmt.Sprintf("Restore last selection for this directory (%d files)?", len(rec.Paths)),
+			accept: func(m *model) tea.Cmd {
+				if missing := m.applyRecord(rec); len(missing) > 0 {
+					m.warning = fmt.Sprintf("restore: %d missing: %s", len(missing), strings.Join(missing, ", "))
+				}
+				return m.flash(fmt.Sprintf("Restored %d files", len(selectedFiles(m.root))))
+			},
+		}
+	}
+	// Init counts the starting selection
+	m.tokens.key, m.tokens.counting = m.tokenKey(), true
+	return m
 }
 
-func flatten(root *node) []list.Item {
+// flatten lists the visible nodes under root in display order, skipping
+// any node (and its subtree) for which hide returns true. Directories with
+// more than page children list them a page at a time, followed by a "show
+// more" entry; page <= 0 lists everything.
+func flatten(root *node, hide func(*node) bool, page int) []list.Item {
 	var flat []list.Item
 	var recurse func(*node, int)
+	var children func(*node, int)
 	recurse = func(n *node, d int) {
-		flat = append(flat, item{n, d})
+		if hide != nil && hide(n) {
+			return
+		}
+		flat = append(flat, item{node: n, depth: d})
 		if n.expanded {
-			for _, c := range n.children {
-				recurse(c, d+1)
-			}
+			children(n, d+1)
 		}
 	}
-	for _, c := range root.children {
-		recurse(c, 0)
+	children = func(n *node, d int) {
+		limit := len(n.children)
+		if page > 0 {
+			limit = min(max(n.shown, page), limit)
+		}
+		for _, c := range n.children[:limit] {
+			recurse(c, d)
+		}
+		if limit < len(n.children) {
+			flat = append(flat, item{node: n, depth: d, more: true})
+		}
 	}
+	children(root, 0)
 	return flat
 }
 
+// revealPath loads and expands the directories leading to path and returns
+// its node, or nil if path is not in the tree.
+func revealPath(root *node, path string, watcher *fsnotify.Watcher) *node {
+	return lookupPath(root, path, watcher, true)
+}
+
+// lookupPath returns the node for path, loading the directories leading to
+// it and expanding them if expand is set.
+func lookupPath(root *node, path string, watcher *fsnotify.Watcher, expand bool) *node {
+	rel, err := filepath.Rel(root.path, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	n := root
+	if rel == "." {
+		return n
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if !n.childrenLoaded {
+			loadChildren(n, watcher)
+		}
+		if expand {
+			n.expanded = true
+		}
+		var next *node
+		for _, c := range n.children {
+			if filepath.Base(c.path) == part {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
+
+// layout sizes the panes to the window, keeping the tree cursor on the
+// same entry and the viewport scrolled to the same line.
+func (m *model) layout() {
+	index := m.list.Index()
+	m.list.SetSize(m.treeWidth(), m.height-4)
+	m.list.Select(index)
+	m.textarea.SetWidth(m.width/2 - 2)
+	m.textarea.SetHeight(m.textareaHeight())
+	offset := m.viewport.YOffset
+	m.viewport.Width = m.width/2 - 2
+	m.viewport.Height = m.height - 10
+	if m.focus == responseView {
+		m.renderResponse()
+	}
+	m.viewport.SetYOffset(offset)
+}
+
+// treeWidth is the width of the left pane, which takes the whole window in
+// focus mode.
+func (m model) treeWidth() int {
+	if m.focusMode {
+		return m.width
+	}
+	return m.width / 2
+}
+
+// textareaHeight returns the preferred request height clamped so the Copy
+// button stays on screen.
+func (m model) textareaHeight() int {
+	if m.opts.TextareaHeight <= 0 {
+		return m.clampTextareaHeight(m.height)
+	}
+	return m.clampTextareaHeight(m.opts.TextareaHeight)
+}
+
+func (m model) clampTextareaHeight(h int) int {
+	return min(max(h, minTextareaHeight), max(m.height-10, minTextareaHeight))
+}
+
+// recopy regenerates the prompt from the files on disk, running the
+// commands again, and copies it without quitting.
+func (m *model) recopy() tea.Cmd {
+	if len(selectedFiles(m.root)) == 0 {
+		return m.flash("Nothing selected to copy")
+	}
+	if len(m.opts.Commands) > 0 {
+		m.commands.results = nil
+		m.commands.running = false
+		m.commands.copyAfter = true
+		return tea.Batch(m.startCommands(), m.flash("Running commands…"))
+	}
+	return m.copyFresh()
+}
+
+// checkRequest enforces --require-request before the prompt is copied:
+// with no request it warns and returns false along with the command that
+// focuses the request box.
+func (m *model) checkRequest() (tea.Cmd, bool) {
+	if !m.opts.RequireRequest || strings.TrimSpace(m.textarea.Value()) != "" {
+		return nil, true
+	}
+	m.warning = "A request is required. Describe the task before copying."
+	m.focus = textAreaView
+	return m.textarea.Focus(), false
+}
+
+// copyFresh copies the prompt as the files are now, refreshing the review
+// if it is showing.
+func (m *model) copyFresh() tea.Cmd {
+	files := selectedFiles(m.root)
+	var prompt string
+	if m.focus == acceptView {
+		offset := m.viewport.YOffset
+		prompt = m.renderReview()
+		m.viewport.SetYOffset(offset)
+	} else {
+		prompt = m.generatePrompt()
+	}
+	if m.warning = m.budgetError(prompt); m.warning != "" {
+		return nil
+	}
+	if m.focus != acceptView {
+		m.root.markIncluded()
+	}
+	if err := copyToClipboard(prompt, m.opts); err != nil {
+		m.warning = "copy: " + err.Error()
+		return nil
+	}
+	return m.flash(fmt.Sprintf("Copied fresh prompt: %d files, %s bytes, ~%s tokens", len(files), formatCount(len(prompt)), formatCount(m.opts.countTokens(prompt))))
+}
+
+// resizeTextarea grows or shrinks the request box and saves the new height
+// to the project config when one exists.
+func (m *model) resizeTextarea(delta int) tea.Cmd {
+	m.opts.TextareaHeight = m.clampTextareaHeight(m.textareaHeight() + delta)
+	m.layout()
+	if err := saveConfigValue(filepath.Join(m.root.path, configFileName), "textarea_height", m.opts.TextareaHeight); err != nil {
+		return m.flash("Couldn't save height: " + err.Error())
+	}
+	return nil
+}
+
+// selectedItems lists the selected files flat, labelled by their path
+// relative to root.
+func selectedItems(root *node) []list.Item {
+	var items []list.Item
+	for _, n := range selectedNodes(root) {
+		rel, err := filepath.Rel(root.path, n.path)
+		if err != nil {
+			rel = n.path
+		}
+		items = append(items, item{node: n, label: rel})
+	}
+	return items
+}
+
+// hidden reports whether n is filtered out of the tree view: dotfiles and
+// dot-directories unless shown with ".", other types under the x filter,
+// and empty directories with E or --include.
+func (m *model) hidden(n *node) bool {
+	if !m.showHidden && strings.HasPrefix(filepath.Base(n.path), ".") || m.extHidden(n) {
+		return true
+	}
+	// with --include, directories without a matching file are noise
+	return (m.hideEmpty || len(m.root.include) > 0 || m.root.only != nil) && isEmptyDir(n)
+}
+
+// reflatten rebuilds the list items from the tree, keeping the cursor on
+// the same node when it is still visible.
+func (m *model) reflatten() {
+	var cur string
+	var curPinned bool
+	if sel, ok := m.list.SelectedItem().(item); ok {
+		cur, curPinned = sel.node.path, sel.pinned
+	}
+	if m.search.active && m.search.kind == contentFilter {
+		m.flatItems = searchTreeItems(m.root, m.search.matches, m.watcher)
+	} else if m.search.active {
+		m.flatItems = searchItems(m.root, m.search.matches, m.watcher)
+	} else if m.selectedView {
+		m.flatItems = selectedItems(m.root)
+	} else {
+		m.flatItems = append(m.pinnedItems(), flatten(m.root, m.hidden, m.opts.MaxDirEntries)...)
+	}
+	m.list.SetItems(m.flatItems)
+	if m.delegate.sizeBars {
+		m.delegate.maxSize = maxFileSize(m.root)
+		m.list.SetDelegate(m.delegate)
+	}
+	if !curPinned || !m.selectItem(cur, true) {
+		m.selectPath(cur)
+	}
+}
+
+// collapseParent collapses the directory enclosing sel and moves onto it.
+func (m *model) collapseParent(sel item) {
+	parent := sel.node.parent
+	if sel.more {
+		parent = sel.node
+	}
+	if parent == nil || parent == m.root {
+		return
+	}
+	m.visit()
+	parent.expanded = false
+	m.reflatten()
+	m.selectPath(parent.path)
+}
+
+// selectPath moves the cursor to the tree entry for path, if it is listed.
+func (m *model) selectPath(path string) bool {
+	return m.selectItem(path, false)
+}
+
+func (m *model) selectItem(path string, pinned bool) bool {
+	for idx, it := range m.flatItems {
+		if it := it.(item); it.node.path == path && !it.more && it.pinned == pinned {
+			m.list.Select(idx)
+			return true
+		}
+	}
+	return false
+}
+
+// maxFileSize returns the largest file size among loaded nodes.
+func maxFileSize(n *node) int64 {
+	var biggest int64
+	if !n.isDir {
+		biggest = n.size
+	}
+	for _, c := range n.children {
+		biggest = max(biggest, maxFileSize(c))
+	}
+	return biggest
+}
+
 func (m model) Init() tea.Cmd {
-	return tea.Batch(watchCmd(m.watcher), textarea.Blink)
+	cmds := []tea.Cmd{watchCmd(m.watcher), m.control.wait(), textarea.Blink}
+	if m.root != nil {
+		cmds = append(cmds, gitStatusCmd(m.gitSeq, m.root.path), m.countPrompt(m.tokens.seq), m.countVisibleFiles())
+	}
+	return tea.Batch(cmds...)
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	request, page := m.textarea.Value(), m.visiblePage()
+	next, cmd := m.update(msg)
+	nm, ok := next.(model)
+	if !ok || nm.quitting {
+		return next, cmd
+	}
+	cmds := []tea.Cmd{cmd}
+	if mayChangeSelection(msg) || nm.textarea.Value() != request {
+		cmds = append(cmds, nm.recount())
+	}
+	if _, ok := msg.(fsEventMsg); ok || nm.visiblePage() != page {
+		cmds = append(cmds, nm.countVisibleFiles())
+	}
+	return nm, tea.Batch(cmds...)
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	var cmds []tea.Cmd
 	switch msg := msg.(type) {
+	case tokenTickMsg:
+		if msg.seq != m.tokens.seq {
+			return m, nil
+		}
+		return m, m.countPrompt(msg.seq)
+	case gitStatusTickMsg:
+		if msg.seq != m.gitSeq {
+			return m, nil
+		}
+		return m, gitStatusCmd(msg.seq, m.root.path)
+	case responseMsg:
+		return m, m.handleResponse(msg)
+	case commandsMsg:
+		return m, m.handleCommands(msg)
+	case diffMarkersMsg:
+		return m, m.handleDiffMarkers(msg)
+	case gitStatusMsg:
+		if msg.seq == m.gitSeq {
+			m.delegate.gitStates = msg.states
+			m.list.SetDelegate(m.delegate)
+			// the index may have changed under unchanged files
+			clear(m.diffMarkers)
+		}
+		return m, nil
+	case fileTokensMsg:
+		for p, ft := range msg {
+			m.fileTokens[p] = ft
+		}
+		return m, nil
+	case tokenCountMsg:
+		if msg.seq == m.tokens.seq {
+			m.tokens.count = msg.count
+			m.tokens.stats = msg.stats
+			m.tokens.counted = true
+			m.tokens.counting = false
+		}
+		return m, nil
 	case tea.WindowSizeMsg:
-		m.width = msg.Width
-		m.height = msg.Height
-		m.list.SetSize(msg.Width/2, msg.Height-4)
-		m.textarea.SetWidth(msg.Width/2 - 2)
-		m.textarea.SetHeight(msg.Height - 10)
+		if m.width == 0 && m.height == 0 {
+			m.width, m.height = msg.Width, msg.Height
+			m.layout()
+			return m, nil
+		}
+		// coalesce bursts of resizes, e.g. while dragging a window edge
+		m.resizeSeq++
+		seq := m.resizeSeq
+		return m, tea.Tick(resizeDebounce, func(time.Time) tea.Msg {
+			return resizeMsg{seq: seq, width: msg.Width, height: msg.Height}
+		})
+	case resizeMsg:
+		if msg.seq == m.resizeSeq {
+			m.width, m.height = msg.width, msg.height
+			m.layout()
+		}
 		return m, nil
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c", "q":
+			// q is text while typing, e.g. a request or follow-up
+			typing := m.focus == textAreaView || m.focus == fileTreeView && m.list.SettingFilter()
+			if msg.String() == "q" && (typing || m.search.editing || m.extFilter.editing || m.question.editing) {
+				break
+			}
 			m.quitting = true
 			return m, tea.Quit
 		}
+		if m.focus == fileTreeView && m.extFilter.editing {
+			return m, m.updateExtFilter(msg)
+		}
+		if m.question.editing {
+			return m, m.updateQuestion(msg)
+		}
+		if m.confirm != nil && (m.focus != fileTreeView || !m.list.SettingFilter()) {
+			switch msg.String() {
+			case "y":
+				c := m.confirm
+				m.confirm = nil
+				return m, c.accept(&m)
+			case "n", "esc":
+				m.confirm = nil
+				return m, nil
+			}
+		}
+		if m.followUp != nil && m.focus == textAreaView {
+			return m, m.updateFollowUp(msg)
+		}
+		if msg.String() == "ctrl+r" && !m.search.editing {
+			return m, m.recopy()
+		}
+		if (m.showPreview && m.focus == fileTreeView) || m.focus == acceptView || m.focus == responseView || m.focus == patchView {
+			if m.scrollPane(msg.String()) {
+				return m, nil
+			}
+		}
+		if m.showPreview && m.focus == fileTreeView && !m.list.SettingFilter() {
+			switch msg.String() {
+			case "shift+down":
+				return m, m.extendRange(1)
+			case "shift+up":
+				return m, m.extendRange(-1)
+			case "U":
+				return m, m.clearRange()
+			}
+		}
+		if m.focus == fileTreeView && m.search.editing {
+			switch msg.String() {
+			case "esc":
+				m.closeSearch()
+				return m, nil
+			case "enter":
+				m.search.editing = false
+				m.search.input.Blur()
+				return m, nil
+			}
+			query := m.search.input.Value()
+			m.search.input, cmd = m.search.input.Update(msg)
+			cmds = append(cmds, cmd)
+			if m.search.input.Value() != query {
+				cmds = append(cmds, m.restartSearch())
+			}
+			return m, tea.Batch(cmds...)
+		}
+		if m.focus == fileTreeView && m.search.active && !m.list.SettingFilter() {
+			switch msg.String() {
+			case "esc":
+				if m.list.FilterState() == list.Unfiltered {
+					m.closeSearch()
+					return m, nil
+				}
+			case "ctrl+f", "ctrl+p", "ctrl+g":
+				m.search.editing = true
+				return m, m.search.input.Focus()
+			}
+		}
 		if m.focus == fileTreeView {
+			if msg.String() == "ctrl+t" {
+				m.caseSensitive = !m.caseSensitive
+				m.applyFilterMode()
+				if m.caseSensitive {
+					return m, m.flash("Case-sensitive filtering")
+				}
+				return m, m.flash("Case-insensitive filtering")
+			}
 			// don't expand/select entries if user is trying to edit the filter
 			if !m.list.SettingFilter() {
 				switch msg.String() {
 				case "enter":
-					if sel, ok := m.list.SelectedItem().(item); ok {
+					if sel, ok := m.list.SelectedItem().(item); ok && sel.more {
+						idx := m.list.Index()
+						sel.node.shown = max(sel.node.shown, m.opts.MaxDirEntries) + m.opts.MaxDirEntries
+						m.reflatten()
+						m.list.Select(idx)
+					} else if ok {
 						if sel.node.isDir {
-							curPath := sel.node.path
+							m.visit()
 							sel.node.expanded = !sel.node.expanded
 							if sel.node.expanded && !sel.node.childrenLoaded {
 								loadChildren(sel.node, m.watcher)
 							}
-							m.flatItems = flatten(m.root)
-							m.list.SetItems(m.flatItems)
-							for idx, it := range m.flatItems {
-								if it.(item).node.path == curPath {
-									m.list.Select(idx)
-									break
-								}
-							}
+							m.reflatten()
 						}
 					}
 				case " ":
-					if sel, ok := m.list.SelectedItem().(item); ok {
+					if sel, ok := m.list.SelectedItem().(item); ok && sel.node.ignored {
+						cmds = append(cmds, m.flash("Ignored files can't be selected"))
+					} else if ok && !sel.more {
 						on := !sel.node.selected
 						sel.node.toggleSelect(on)
+						if !m.root.keepsIncluded() {
+							m.root.clearIncluded()
+						}
+						if m.selectedView {
+							m.reflatten()
+						}
+					}
+				case "backspace", "-":
+					if sel, ok := m.list.SelectedItem().(item); ok && !m.search.active && !m.selectedView {
+						m.collapseParent(sel)
 					}
+				case "h", "left":
+					// collapse an open directory in place, otherwise its parent;
+					// in flat views the keys keep paging the list
+					if sel, ok := m.list.SelectedItem().(item); ok && !m.search.active && !m.selectedView {
+						if sel.node.isDir && sel.node.expanded && !sel.more {
+							sel.node.expanded = false
+							m.reflatten()
+							m.selectPath(sel.node.path)
+						} else {
+							m.collapseParent(sel)
+						}
+						cmds = append(cmds, m.syncPreview())
+						return m, tea.Batch(cmds...)
+					}
+				case "[", "alt+left":
+					if !m.search.active && !m.selectedView {
+						m.navigate(&m.nav.back, &m.nav.forward)
+					}
+				case "]", "alt+right":
+					if !m.search.active && !m.selectedView {
+						m.navigate(&m.nav.forward, &m.nav.back)
+					}
+				case "H":
+					m.hints = !m.hints
+				case "I":
+					m.root.clearIncluded()
+				case "P":
+					if sel, ok := m.list.SelectedItem().(item); ok && !sel.more && sel.node != m.root {
+						if err := m.togglePin(sel.node); err != nil {
+							m.warning = "pins: " + err.Error()
+						}
+						m.reflatten()
+					}
+				case "m":
+					if sel, ok := m.list.SelectedItem().(item); ok && !sel.more && sel.node.isDir {
+						sel.node.marked = !sel.node.marked
+						if sel.node.marked && !sel.node.childrenLoaded {
+							loadChildren(sel.node, m.watcher)
+						}
+					}
+				case "R":
+					recipe, err := encodeRecipe(newRecord(m.root, m.opts))
+					if err == nil {
+						err = copyToClipboard(recipe, m.opts)
+					}
+					if err != nil {
+						m.warning = "recipe: " + err.Error()
+					} else {
+						cmds = append(cmds, m.flash(fmt.Sprintf("Recipe copied (%d files)", len(selectedFiles(m.root)))))
+					}
+				case "B":
+					cmds = append(cmds, m.offerTrim())
+				case "v":
+					m.selectedView = !m.selectedView
+					m.applyFilterMode()
+					m.reflatten()
+				case "X":
+					if n := len(selectedFiles(m.root)); n > clearConfirmThreshold {
+						m.confirm = &confirmation{
+							prompt: fmt.Sprintf("Clear all %d selected files?", n),
+							accept: func(m *model) tea.Cmd {
+								m.root.clearSelection()
+								m.root.clearIncluded()
+								m.reflatten()
+								return m.flash("Selection cleared")
+							},
+						}
+					} else {
+						m.root.clearSelection()
+						m.root.clearIncluded()
+						m.reflatten()
+						cmds = append(cmds, m.flash("Selection cleared"))
+					}
+				case "r":
+					m.root.gitignore.reset()
+					refreshTree(m.root, m.watcher)
+					m.reflatten()
+					m.previewPath = ""
+					cmds = append(cmds, m.flash("Refreshed"), m.refreshGitStatus(), m.countVisibleFiles())
+				case "M":
+					states, n := selectGitChanged(m.root, m.watcher)
+					switch {
+					case states == nil:
+						cmds = append(cmds, m.flash("Not in a git repository"))
+					case n == 0:
+						cmds = append(cmds, m.flash("No changed files"))
+					default:
+						cmds = append(cmds, m.flash(fmt.Sprintf("Selected %d changed files", n)))
+					}
+					if states != nil {
+						m.gitSeq++
+						m.delegate.gitStates = states
+						m.list.SetDelegate(m.delegate)
+					}
+					m.reflatten()
+				case "x":
+					cmds = append(cmds, m.openExtFilter())
+				case "ctrl+x":
+					cmds = append(cmds, m.clearExtFilter())
+				case "i":
+					m.root.showIgnored = !m.root.showIgnored
+					reloadLoaded(m.root, m.watcher)
+					m.reflatten()
+					if m.root.showIgnored {
+						cmds = append(cmds, m.flash("Showing ignored entries dimmed"))
+					} else {
+						cmds = append(cmds, m.flash("Hiding ignored entries"))
+					}
+				case ".":
+					m.showHidden = !m.showHidden
+					m.reflatten()
+					if m.showHidden {
+						cmds = append(cmds, m.flash("Showing dotfiles"))
+					} else {
+						cmds = append(cmds, m.flash("Hiding dotfiles"))
+					}
+				case "E":
+					m.hideEmpty = !m.hideEmpty
+					m.reflatten()
+					if m.hideEmpty {
+						cmds = append(cmds, m.flash("Hiding empty directories"))
+					} else {
+						cmds = append(cmds, m.flash("Showing empty directories"))
+					}
+				case "T":
+					m.delegate.treeTokens = !m.delegate.treeTokens
+					m.list.SetDelegate(m.delegate)
+				case "p":
+					m.showPreview = !m.showPreview
+					m.previewPath = ""
+				case "ctrl+f":
+					cmds = append(cmds, m.openSearch(contentSearch))
+				case "ctrl+p":
+					cmds = append(cmds, m.openSearch(nameSearch))
+				case "ctrl+g":
+					cmds = append(cmds, m.openSearch(contentFilter))
+				case "F":
+					m.focusMode = !m.focusMode
+					m.layout()
 				case "tab":
+					if m.focusMode {
+						break
+					}
 					m.focus = textAreaView
+					m.stopSearch()
 					cmds = append(cmds, m.textarea.Focus())
 				}
 			}
 			m.list, cmd = m.list.Update(msg)
-			cmds = append(cmds, cmd)
+			cmds = append(cmds, cmd, m.syncPreview())
 		} else if m.focus == textAreaView {
+			m.warning = ""
 			switch msg.String() {
+			case "ctrl+up", "ctrl+down":
+				delta := 1
+				if msg.String() == "ctrl+up" {
+					delta = -1
+				}
+				return m, m.resizeTextarea(delta)
 			case "tab":
 				m.focus = acceptView
 				m.textarea.Blur()
+				m.reviewCursor = 0
+				cmds = append(cmds, m.startCommands())
+				m.renderReview()
+				m.viewport.GotoTop()
 			}
 			m.textarea, cmd = m.textarea.Update(msg)
 			cmds = append(cmds, cmd)
 		} else if m.focus == acceptView {
 			switch msg.String() {
 			case "enter":
-				m.prompt = m.generatePrompt()
+				if m.commandsPending() {
+					return m, m.flash("Waiting for the commands to finish")
+				}
+				if cmd, ok := m.checkRequest(); !ok {
+					return m, cmd
+				}
+				prompt := m.generatePrompt()
+				if m.warning = m.budgetError(prompt); m.warning != "" {
+					return m, nil
+				}
+				m.prompt = prompt
+				m.copyPrompt = m.opts.CopyOnAccept
+				return m, tea.Quit
+			case "c":
+				if m.commandsPending() {
+					return m, m.flash("Waiting for the commands to finish")
+				}
+				if cmd, ok := m.checkRequest(); !ok {
+					return m, cmd
+				}
+				prompt := m.generatePrompt()
+				if m.warning = m.budgetError(prompt); m.warning != "" {
+					return m, nil
+				}
+				m.prompt = prompt
+				m.copyPrompt = true
 				return m, tea.Quit
 			case "tab":
 				m.focus = fileTreeView
+				m.previewPath = ""
+				cmds = append(cmds, m.syncPreview())
+			case "up", "k":
+				m.viewport.LineUp(1)
+			case "down", "j":
+				m.viewport.LineDown(1)
+			case "pgup":
+				m.viewport.PageUp()
+			case "pgdown":
+				m.viewport.PageDown()
+			case "C":
+				cmds = append(cmds, m.copyNextChunk())
+			case "n":
+				m.jumpSection(1)
+			case "N":
+				m.jumpSection(-1)
+			case "d", "delete":
+				cmds = append(cmds, m.deselectSection())
+			case "B":
+				cmds = append(cmds, m.offerTrim())
+			case "s":
+				cmds = append(cmds, m.send())
+			case "m":
+				cmds = append(cmds, m.openModelPicker())
+			case "r":
+				if m.response.model != "" {
+					m.focus = responseView
+					m.renderResponse()
+					m.viewport.GotoTop()
+				}
 			}
+		} else if m.focus == responseView {
+			m.warning = ""
+			cmds = append(cmds, m.updateResponse(msg.String()))
+		} else if m.focus == patchView {
+			m.warning = ""
+			cmds = append(cmds, m.updatePatch(msg.String()))
 		}
 	case fsEventMsg:
 		ev := fsnotify.Event(msg)
 		dir := filepath.Dir(ev.Name)
 		node := findNode(m.root, dir)
+		if node != nil {
+			invalidateEmpty(node)
+		}
+		if node != nil && filepath.Base(ev.Name) == ".gitignore" {
+			m.root.gitignore.forget(dir)
+			reloadLoaded(node, m.watcher)
+			m.reflatten()
+		}
 		if node != nil && node.expanded && ev.Op != fsnotify.Write {
 			loadChildren(node, m.watcher)
-			m.flatItems = flatten(m.root)
-			m.list.SetItems(m.flatItems)
+			m.reflatten()
 		}
-		cmds = append(cmds, watchCmd(m.watcher))
+		if ev.Name == m.previewPath || dir == m.previewPath {
+			m.previewPath = ""
+			cmds = append(cmds, m.syncPreview())
+		}
+		cmds = append(cmds, watchCmd(m.watcher), m.scheduleGitStatus())
+	case searchResultMsg:
+		cmds = append(cmds, m.handleSearchResult(msg))
+	case statusExpiredMsg:
+		m.expireStatus(int(msg))
 	case fsErrMsg:
 		m.err = error(msg)
 		cmds = append(cmds, watchCmd(m.watcher))
+	case controlMsg:
+		summary, bad := m.applyControl(msg)
+		if len(bad) > 0 {
+			m.warning = "control: " + strings.Join(bad, "; ")
+		}
+		cmds = append(cmds, m.flash(summary), m.control.wait())
+	case controlErrMsg:
+		m.warning = error(msg).Error()
+		cmds = append(cmds, m.control.wait())
 	default:
 		var cmd2 tea.Cmd
 		m.list, cmd = m.list.Update(msg)
@@ -294,18 +1380,166 @@ func (m model) View() string {
 	if m.quitting {
 		return "Bye!\n"
 	}
-	left := lipgloss.NewStyle().Width(m.width / 2).Height(m.height - 4).Render(m.list.View())
+	if m.focus == fileTreeView {
+		m.list.Styles.Title = ui.listTitle
+	} else {
+		m.list.Styles.Title = ui.listTitleBlurred
+	}
+	left := lipgloss.NewStyle().Width(m.treeWidth()).Height(m.height - 4).Render(m.list.View())
+	footer := m.footer()
+	if m.root != nil {
+		style := blurredStyle
+		if m.overBudget() {
+			style = warningStyle
+		}
+		bar := style.Render(m.statusBar())
+		if m.width > 0 {
+			bar = lipgloss.NewStyle().MaxWidth(m.width).Render(bar)
+		}
+		footer = bar + "\n" + footer
+	}
+	if m.focusMode {
+		return left + "\n" + footer
+	}
 	rightTop := "User Request:"
+	if m.followUp != nil {
+		rightTop = "Follow-up to " + m.response.model + ":"
+		if m.followUp.refresh {
+			rightTop = "Follow-up to " + m.response.model + " with refreshed files:"
+		}
+	}
 	rightMid := m.textarea.View()
 	rightBot := blurredButton
-	if m.focus == acceptView {
+	switch {
+	case m.focus == acceptView:
+		rightTop = m.reviewHeading()
+		rightMid = m.viewport.View()
 		rightBot = focusedButton
+	case m.focus == responseView:
+		rightTop = m.responseHeading()
+		rightMid = m.viewport.View()
+	case m.focus == patchView:
+		rightTop = m.patchHeading()
+		rightMid = m.viewport.View()
+	case m.focus == fileTreeView && m.showPreview && m.previewPath != "":
+		rightTop = "Preview: " + filepath.Base(m.previewPath)
+		rightMid = m.viewport.View()
+	}
+	if m.overBudget() {
+		rightBot = warningStyle.Render("[ Copy ]")
+	}
+	switch m.focus {
+	case acceptView:
+		rightBot += "  " + blurredStyle.Render("[ Send ]")
+		if cost, ok := m.opts.sendCost(m.tokens.count); ok && m.tokens.counted {
+			rightBot += blurredStyle.Render(" ~" + formatCost(cost))
+		}
+	case responseView:
+		rightBot += "  " + focusedStyle.Render("[ Send ]")
+	}
+	if m.root != nil {
+		style := blurredStyle
+		if m.overBudget() {
+			style = warningStyle
+		}
+		rightBot += "  " + style.Render(m.tokenStatus())
+		if m.overBudget() {
+			rightBot += blurredStyle.Render("  B: trim to fit")
+		}
+	}
+	if m.focus == acceptView && !m.opts.CopyOnAccept {
+		action := "print"
+		if m.opts.Pipe != "" {
+			action = "pipe"
+		}
+		rightBot += blurredStyle.Render("  enter: " + action + "  c: copy")
 	}
-	right := lipgloss.NewStyle().Width(m.width / 2).Height(m.height - 4).PaddingLeft(2).Render(rightTop + "\n" + rightMid + "\n\n" + rightBot)
-	return lipgloss.JoinHorizontal(lipgloss.Top, left, right) + "\nPress q to quit."
+	if m.focus == acceptView && len(m.reviewSections) > 0 {
+		rightBot += blurredStyle.Render("  n/N: next/prev file  d: deselect")
+	}
+	if m.focus == acceptView {
+		hint := "  C: copy in chunks"
+		if m.chunks != nil {
+			hint = fmt.Sprintf("  C: copy chunk %d/%d", m.chunkIndex%len(m.chunks)+1, len(m.chunks))
+		}
+		rightBot += blurredStyle.Render(hint)
+	}
+	if m.warning != "" {
+		rightBot = warningStyle.Render(m.warning) + "\n" + rightBot
+	}
+	if m.focus != fileTreeView {
+		rightTop = titleStyle.Render(rightTop)
+	}
+	rightStyle := lipgloss.NewStyle().Width(m.width / 2).Height(m.height - 4).PaddingLeft(2)
+	if ui.separator {
+		rightStyle = rightStyle.PaddingLeft(1).Border(lipgloss.ThickBorder(), false, false, false, true)
+	}
+	right := rightStyle.Render(rightTop + "\n" + rightMid + "\n\n" + rightBot)
+	return lipgloss.JoinHorizontal(lipgloss.Top, left, right) + "\n" + footer
+}
+
+// keyHints is the one-line reminder of the main keys for the focused pane.
+func (m model) keyHints() string {
+	switch m.focus {
+	case textAreaView:
+		if m.followUp != nil {
+			return "tab: send follow-up  ctrl+o: refresh files  esc: back to answer  ctrl+c: quit"
+		}
+		return "tab: review  ctrl+up/down: resize  ctrl+c: quit"
+	case acceptView:
+		hints := "enter: accept  c: copy  s: send  m: model  tab: tree  q: quit"
+		if m.response.model != "" {
+			hints = "enter: accept  c: copy  s: send  m: model  r: response  tab: tree  q: quit"
+		}
+		return hints
+	case responseView:
+		return "f: follow up  c: copy answer  w: save  e: export  a: apply diffs  s: new conversation  m: model  tab: review  esc: cancel or back  q: quit"
+	case patchView:
+		return "space: toggle hunk  a: toggle file  n/N: next/prev hunk  enter: apply accepted  esc: back  q: quit"
+	}
+	return "space: select  enter: open  /: filter  ctrl+f: search  tab: request  H: hide hints  q: quit"
+}
+
+func (m model) footer() string {
+	footer := "Press q to quit."
+	if m.hints {
+		footer = blurredStyle.Render(m.keyHints())
+	}
+	if m.root != nil {
+		if files := selectedFiles(m.root); len(files) > 0 {
+			footer = blurredStyle.Render(extensionSummary(files)) + "  " + footer
+		}
+	}
+	if m.search.active {
+		footer = m.searchFooter()
+	}
+	if m.extFilter.editing {
+		footer = m.extFilter.input.View() + "  " + blurredStyle.Render("enter: apply  esc: cancel")
+	}
+	if m.question.editing {
+		hints := "enter: use  esc: cancel"
+		if m.question.input.ShowSuggestions {
+			hints = "tab: complete  " + hints
+		}
+		footer = m.question.input.View() + "  " + blurredStyle.Render(hints)
+	}
+	if status, ok := m.currentStatus(); ok {
+		footer = status
+	}
+	if m.confirm != nil {
+		footer = m.confirm.prompt + " y/n"
+	}
+	if m.width > 0 {
+		// one line, so the panes above keep their height
+		footer = lipgloss.NewStyle().MaxWidth(m.width).Render(footer)
+	}
+	return footer
 }
 
 func watchCmd(w *fsnotify.Watcher) tea.Cmd {
+	if w == nil {
+		return nil
+	}
 	return func() tea.Msg {
 		select {
 		case ev := <-w.Events:
@@ -330,107 +1564,69 @@ func findNode(n *node, path string) *node {
 	return nil
 }
 
-func (m model) generatePrompt() string {
-	var sb strings.Builder
-	sb.WriteString("<file_tree>\n")
-	sb.WriteString(generateFileTree(m.root))
-	sb.WriteString("</file_tree>\n")
-	selectedFiles := []string{}
-	var collect func(n *node)
-	collect = func(n *node) {
-		if n.selected && !n.isDir {
-			selectedFiles = append(selectedFiles, n.path)
-		}
-		if n.childrenLoaded {
-			for _, c := range n.children {
-				collect(c)
-			}
-		}
-	}
-	collect(m.root)
-	for _, p := range selectedFiles {
-		sb.WriteString("<file>\n<file_path>" + p + "</file_path>\n<file_content>\n")
-		b, err := os.ReadFile(p)
-		var content string
-		if err != nil || strings.Contains(string(b), "\x00") {
-			content = "[Binary file]"
-		} else {
-			content = string(b)
-		}
-		sb.WriteString(content)
-		sb.WriteString("\n</file_content>\n</file>\n")
-	}
-	sb.WriteString("<user_request>\n" + m.textarea.Value() + "\n</user_request>")
-	return sb.String()
-}
-
-func generateFileTree(root *node) string {
-	var sb strings.Builder
-	children := []*node{}
-	for _, c := range root.children {
-		if c.selected || hasSelected(c) {
-			children = append(children, c)
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "file" || os.Args[1] == "decode") {
+		run := runFile
+		if os.Args[1] == "decode" {
+			run = runDecode
 		}
-	}
-	for i, c := range children {
-		isLast := i == len(children)-1
-		sb.WriteString(generateTreeRec(c, "", isLast))
-	}
-	return sb.String()
-}
-
-func generateTreeRec(n *node, prefix string, isLast bool) string {
-	var s string
-	name := filepath.Base(n.path)
-	if isLast {
-		s = prefix + "└── " + name + "\n"
-		prefix += "    "
-	} else {
-		s = prefix + "├── " + name + "\n"
-		prefix += "│   "
-	}
-	children := []*node{}
-	for _, c := range n.children {
-		if c.selected || hasSelected(c) {
-			children = append(children, c)
+		if err := run(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
 		}
+		return
 	}
-	for i, c := range children {
-		isLastChild := i == len(children)-1
-		s += generateTreeRec(c, prefix, isLastChild)
+	opts, err := parseOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
-	return s
-}
-
-func hasSelected(n *node) bool {
-	if n.selected && !n.isDir {
-		return true
+	if err := applyTheme(opts.Theme, opts.Background); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
-	if n.childrenLoaded {
-		for _, c := range n.children {
-			if hasSelected(c) {
-				return true
-			}
+	progOpts := []tea.ProgramOption{tea.WithAltScreen()}
+	if opts.Stdout {
+		// draw on the terminal so stdout carries only the prompt
+		if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+			defer tty.Close()
+			progOpts = append(progOpts, tea.WithOutput(tty))
+		} else {
+			progOpts = append(progOpts, tea.WithOutput(os.Stderr))
 		}
 	}
-	return false
-}
-
-func main() {
-	path := flag.String("path", ".", "path to directory to open")
-	flag.Parse()
-	p := tea.NewProgram(newModel(*path), tea.WithAltScreen())
+	status := 0
+	p := tea.NewProgram(newModel(opts), progOpts...)
 	fm, err := p.Run()
 	if err != nil {
-		fmt.Println("Error:", err)
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 	if m, ok := fm.(model); ok && m.prompt != "" {
-		cmd := exec.Command("pbcopy")
-		cmd.Stdin = strings.NewReader(m.prompt)
-		_ = cmd.Run()
+		for _, s := range promptSinks(opts, m.copyPrompt, m.root.path) {
+			if err := s.write(m.prompt); err != nil {
+				fmt.Fprintf(os.Stderr, "Error %s: %v\n", s.action, err)
+				status = 1
+			}
+		}
+		if opts.Explain != "" {
+			if err := writeRecord(opts.Explain, newRecord(m.root, m.opts)); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+			}
+		}
 	}
-	if m, ok := fm.(model); ok {
+	if m, ok := fm.(model); ok && m.root != nil && len(selectedFiles(m.root)) > 0 {
+		if err := saveSelection(m.root, m.opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error saving selection:", err)
+		}
+	}
+	if m, ok := fm.(model); ok && m.watcher != nil {
 		m.watcher.Close()
 	}
+	if m, ok := fm.(model); ok {
+		m.control.close()
+	}
+	if status != 0 {
+		os.Exit(status)
+	}
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-runewidth"
+)
+
+// runCmd runs cmd and the commands of any batch it returns, collecting
+// the messages they produce.
+func runCmd(cmd tea.Cmd) []tea.Msg {
+	if cmd == nil {
+		return nil
+	}
+	msg := cmd()
+	if batch, ok := msg.(tea.BatchMsg); ok {
+		var msgs []tea.Msg
+		for _, c := range batch {
+			msgs = append(msgs, runCmd(c)...)
+		}
+		return msgs
+	}
+	return []tea.Msg{msg}
+}
+
+func TestResizeBurst(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 50 {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%02d.txt", i)), []byte("x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts, err := parseOptions([]string{"--path", dir, "--no-watch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tm tea.Model = newModel(opts)
+	tm, _ = tm.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m := tm.(model)
+	m.list.Select(30)
+	want := m.list.SelectedItem().(item).node.path
+
+	sizes := []tea.WindowSizeMsg{{Width: 100, Height: 30}, {Width: 90, Height: 25}, {Width: 80, Height: 20}, {Width: 70, Height: 15}}
+	var resizes []resizeMsg
+	tm = m
+	for _, size := range sizes {
+		var cmd tea.Cmd
+		tm, cmd = tm.Update(size)
+		for _, msg := range runCmd(cmd) {
+			if r, ok := msg.(resizeMsg); ok {
+				resizes = append(resizes, r)
+			}
+		}
+	}
+	if len(resizes) != len(sizes) {
+		t.Fatalf("got %d resize messages, want %d", len(resizes), len(sizes))
+	}
+	applied := 0
+	for _, r := range resizes {
+		before := tm.(model)
+		tm, _ = tm.Update(r)
+		after := tm.(model)
+		if after.width != before.width || after.height != before.height {
+			applied++
+			if r.seq != resizes[len(resizes)-1].seq {
+				t.Errorf("applied stale resize seq %d", r.seq)
+			}
+		}
+	}
+	m = tm.(model)
+	if applied != 1 {
+		t.Errorf("applied %d resizes, want 1", applied)
+	}
+	last := sizes[len(sizes)-1]
+	if m.width != last.Width || m.height != last.Height {
+		t.Errorf("size = %dx%d, want %dx%d", m.width, m.height, last.Width, last.Height)
+	}
+	if got := m.list.SelectedItem().(item).node.path; got != want {
+		t.Errorf("cursor on %s, want %s", got, want)
+	}
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+func TestRenderWideNames(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"日本語のとても長いファイル名.txt", "emoji🎉🎉🎉🎉🎉🎉🎉🎉.go", "한국어파일이름입니다.md", "中文目录"}
+	var items []list.Item
+	for i, name := range names {
+		n := &node{path: filepath.Join(dir, name), isDir: i == len(names)-1, size: int64(100 * (i + 1))}
+		items = append(items, item{node: n, depth: i % 2})
+	}
+	for _, width := range []int{12, 20, 31} {
+		for _, extras := range []bool{false, true} {
+			d := customDelegate{DefaultDelegate: list.NewDefaultDelegate(), sizeBars: extras, maxSize: 400, treeTokens: extras, tokens: map[string]fileTokens{}}
+			lm := list.New(items, d, width, 20)
+			for index, li := range items {
+				var buf bytes.Buffer
+				d.Render(&buf, lm, index, li)
+				for _, line := range strings.Split(ansiEscape.ReplaceAllString(buf.String(), ""), "\n") {
+					if w := runewidth.StringWidth(line); w > width {
+						t.Errorf("width %d, extras %v: %q is %d columns wide", width, extras, line, w)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTypingQDoesNotQuit(t *testing.T) {
+	opts, err := parseOptions([]string{"--path", t.TempDir(), "--no-watch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tm tea.Model = newModel(opts)
+	tm, _ = tm.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m := tm.(model)
+	m.focus = textAreaView
+	m.textarea.Focus()
+	tm = m
+	for _, r := range "quit quietly" {
+		key := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}
+		if r == ' ' {
+			key = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{r}}
+		}
+		tm, _ = tm.Update(key)
+	}
+	m = tm.(model)
+	if m.quitting {
+		t.Fatal("typing q in the request quit")
+	}
+	if got := m.textarea.Value(); got != "quit quietly" {
+		t.Errorf("request = %q, want the typed text", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+// navHistoryLimit bounds how many positions back and forward remember.
+const navHistoryLimit = 100
+
+// navHistory is a browser-style back/forward stack of tree cursor paths.
+type navHistory struct {
+	back, forward []string
+}
+
+func pushPath(stack []string, path string) []string {
+	if len(stack) > 0 && stack[len(stack)-1] == path {
+		return stack
+	}
+	stack = append(stack, path)
+	if len(stack) > navHistoryLimit {
+		stack = stack[len(stack)-navHistoryLimit:]
+	}
+	return stack
+}
+
+// visit records the cursor's current entry before a navigation, dropping
+// the forward history.
+func (m *model) visit() {
+	if sel, ok := m.list.SelectedItem().(item); ok {
+		m.nav.back = pushPath(m.nav.back, sel.node.path)
+		m.nav.forward = nil
+	}
+}
+
+// navigate pops a path from one stack, remembering the current entry on
+// the other, and moves the cursor there, revealing it if it was collapsed.
+// Paths that no longer exist are skipped.
+func (m *model) navigate(from, to *[]string) bool {
+	for len(*from) > 0 {
+		path := (*from)[len(*from)-1]
+		*from = (*from)[:len(*from)-1]
+		if revealPath(m.root, path, m.watcher) == nil {
+			continue
+		}
+		if sel, ok := m.list.SelectedItem().(item); ok {
+			*to = pushPath(*to, sel.node.path)
+		}
+		m.reflatten()
+		m.selectPath(path)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type notebook struct {
+	Cells []struct {
+		CellType string          `json:"cell_type"`
+		Source   json.RawMessage `json:"source"`
+	} `json:"cells"`
+}
+
+// notebookCells extracts the code and markdown cells of a Jupyter notebook
+// in percent format, dropping outputs and metadata. ok is false if b isn't
+// a notebook.
+func notebookCells(b []byte) (string, bool) {
+	var nb notebook
+	if err := json.Unmarshal(b, &nb); err != nil || nb.Cells == nil {
+		return "", false
+	}
+	var sb strings.Builder
+	for _, c := range nb.Cells {
+		if c.CellType != "code" && c.CellType != "markdown" {
+			continue
+		}
+		src, ok := cellSource(c.Source)
+		if !ok {
+			return "", false
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("# %% [" + c.CellType + "]\n")
+		sb.WriteString(strings.TrimRight(src, "\n") + "\n")
+	}
+	return sb.String(), true
+}
+
+// cellSource decodes a cell source, which nbformat allows as either a
+// string or a list of lines.
+func cellSource(raw json.RawMessage) (string, bool) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err == nil {
+		return strings.Join(lines, ""), true
+	}
+	return "", false
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// options holds the settings that shape a run. Fields with a JSON name can
+// also be set in the project config file and are written to selection
+// records so the same context can be regenerated later.
+type options struct {
+	Path     string `json:"-"`
+	RepoRoot bool   `json:"-"`
+	Explain  string `json:"-"`
+	Output   string `json:"-"`
+	Append   string `json:"-"`
+	Control  string `json:"-"`
+	Export   string `json:"-"`
+	Pipe     string `json:"-"`
+	PipeOut  string `json:"-"`
+
+	CaseSensitive  bool   `json:"-"`
+	RequireRequest bool   `json:"-"`
+	FromManifest   string `json:"-"`
+	Recipe         string `json:"-"`
+	CopyOnAccept   bool   `json:"-"`
+	Copy           bool   `json:"-"`
+	Stdout         bool   `json:"-"`
+	NoWatch        bool   `json:"-"`
+	SizeBars       bool   `json:"-"`
+	TreeTokens     bool   `json:"-"`
+	HideEmpty      bool   `json:"-"`
+	Hidden         bool   `json:"-"`
+	ShowIgnored    bool   `json:"-"`
+	MaxDirEntries  int    `json:"-"`
+	SearchWorkers  int    `json:"-"`
+	NoAutoSelect   bool   `json:"-"`
+	Hints          bool   `json:"-"`
+
+	ClipboardSelection string `json:"-"`
+	Clipboard          string `json:"clipboard,omitempty"`
+	OSC52Passthrough   string `json:"osc52_passthrough,omitempty"`
+	Theme              string `json:"-"`
+	Background         string `json:"-"`
+	TextareaHeight     int    `json:"textarea_height,omitempty"`
+
+	StatusDuration time.Duration `json:"-"`
+
+	FullTree      bool `json:"full_tree,omitempty"`
+	NoTree        bool `json:"no_tree,omitempty"`
+	NotebookCells bool `json:"notebook_cells,omitempty"`
+	Summary       bool `json:"summary,omitempty"`
+	FileMode      bool `json:"file_mode,omitempty"`
+	FileTokens    bool `json:"file_tokens,omitempty"`
+	Dedupe        bool `json:"dedupe,omitempty"`
+	ScopeNote     bool `json:"scope_note,omitempty"`
+	BundleMarked  bool `json:"bundle_marked,omitempty"`
+	Fence         bool `json:"fence,omitempty"`
+	Guidance      bool `json:"guidance,omitempty"`
+	Diff          bool `json:"diff,omitempty"`
+	RepoInfo      bool `json:"repo_info,omitempty"`
+
+	NoDefaultExcludes bool `json:"no_default_excludes,omitempty"`
+	NoGitignore       bool `json:"no_gitignore,omitempty"`
+	NoTranscripts     bool `json:"no_transcripts,omitempty"`
+
+	Format       string   `json:"format,omitempty"`
+	Template     string   `json:"template,omitempty"`
+	System       string   `json:"system,omitempty"`
+	SystemFile   string   `json:"system_file,omitempty"`
+	PathBase     string   `json:"path_base,omitempty"`
+	MaxFileSize  byteSize `json:"max_file_size,omitempty"`
+	MaxFileSizes extSizes `json:"max_file_sizes,omitempty"`
+	ChunkSize    byteSize `json:"chunk_size,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Budget       int      `json:"budget,omitempty"`
+	StrictBudget bool     `json:"strict_budget,omitempty"`
+	TrimStrategy string   `json:"trim_strategy,omitempty"`
+	BinaryMode   string   `json:"binary_mode,omitempty"`
+	BinaryBytes  int      `json:"binary_bytes,omitempty"`
+	DiffRef      string   `json:"diff_ref,omitempty"`
+	ChangedSince string   `json:"changed_since,omitempty"`
+	SendModel    string   `json:"send_model,omitempty"`
+	Provider     string   `json:"provider,omitempty"`
+	APIBase      string   `json:"api_base,omitempty"`
+	APIKeyEnv    string   `json:"api_key_env,omitempty"`
+	RefreshFiles bool     `json:"refresh_files,omitempty"`
+	ConfirmCost  float64  `json:"confirm_cost,omitempty"`
+	Staged       bool     `json:"staged,omitempty"`
+	Commits      int      `json:"recent_commits,omitempty"`
+	CommitFormat string   `json:"commit_format,omitempty"`
+
+	First    stringList `json:"first,omitempty"`
+	Commands stringList `json:"commands,omitempty"`
+	Select   stringList `json:"select,omitempty"`
+	Include  stringList `json:"include,omitempty"`
+	Exclude  stringList `json:"exclude,omitempty"`
+	// Prices are dollars per million input tokens by model name prefix,
+	// set in the config to add to or correct the built-in prices.
+	Prices map[string]float64 `json:"prices,omitempty"`
+
+	// tmpl is the parsed Template.
+	tmpl *template.Template
+	// ran holds output already captured for Commands, by command, so
+	// they aren't run again.
+	ran map[string]commandResult
+	// system is the system prompt, read from SystemFile or else System.
+	system string
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// parseOptions applies flag defaults, then the project config found at the
+// root (the repository top with --repo-root), then the options of any
+// --recipe, then the command line, so flags win over both.
+func parseOptions(args []string) (options, error) {
+	opts, _, err := parseCommand(os.Args[0], args, nil)
+	return opts, err
+}
+
+// parseCommand parses args like parseOptions for the named command, which
+// may add flags of its own with extra. Flags may come before or after the
+// positional arguments, which are returned.
+func parseCommand(name string, args []string, extra func(*flag.FlagSet)) (options, []string, error) {
+	var probe options
+	pre := flag.NewFlagSet(name, flag.ExitOnError)
+	probe.bind(pre)
+	if extra != nil {
+		extra(pre)
+	}
+	parseInterspersed(pre, args)
+
+	var opts options
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	opts.bind(fs)
+	if extra != nil {
+		extra(fs)
+	}
+	root := probe.Path
+	if probe.RepoRoot {
+		top, err := gitTopLevel(root)
+		if err != nil {
+			return opts, nil, err
+		}
+		root = top
+	}
+	if err := loadConfig(filepath.Join(root, configFileName), &opts); err != nil {
+		return opts, nil, err
+	}
+	// a template named in the config is relative to the project
+	if opts.Template != "" && !filepath.IsAbs(opts.Template) {
+		opts.Template = filepath.Join(root, opts.Template)
+	}
+	if opts.SystemFile != "" && !filepath.IsAbs(opts.SystemFile) {
+		opts.SystemFile = filepath.Join(root, opts.SystemFile)
+	}
+	if probe.Recipe != "" {
+		if err := applyRecipeOptions(probe.Recipe, &opts); err != nil {
+			return opts, nil, err
+		}
+	}
+	rest := parseInterspersed(fs, args)
+	if opts.ClipboardSelection != "clipboard" && opts.ClipboardSelection != "primary" {
+		return opts, nil, fmt.Errorf("invalid --clipboard-selection %q: want clipboard or primary", opts.ClipboardSelection)
+	}
+	if opts.Copy {
+		opts.CopyOnAccept = true
+	} else if opts.Stdout || opts.Pipe != "" {
+		opts.CopyOnAccept = false
+	}
+	switch opts.Clipboard {
+	case "auto", "osc52", "tmux":
+	default:
+		return opts, nil, fmt.Errorf("invalid --clipboard %q: want auto, osc52 or tmux", opts.Clipboard)
+	}
+	switch opts.OSC52Passthrough {
+	case "auto", "none", "tmux", "screen":
+	default:
+		return opts, nil, fmt.Errorf("invalid --osc52-passthrough %q: want auto, none, tmux or screen", opts.OSC52Passthrough)
+	}
+	if info, err := os.Stat(opts.Path); err != nil || !info.IsDir() {
+		src := "--path"
+		if !flagSet(fs, "path") {
+			src = "$" + rootEnv
+		}
+		return opts, nil, fmt.Errorf("%s %q is not a directory", src, opts.Path)
+	}
+	if opts.RepoRoot {
+		opts.Path = root
+	}
+	if _, ok := formats[opts.Format]; !ok {
+		return opts, nil, fmt.Errorf("invalid --format %q: want %s", opts.Format, formatNames())
+	}
+	if opts.Template != "" {
+		if abs, err := filepath.Abs(opts.Template); err == nil {
+			opts.Template = abs
+		}
+		t, err := loadTemplate(opts.Template)
+		if err != nil {
+			return opts, nil, fmt.Errorf("--template: %w", err)
+		}
+		opts.tmpl = t
+	}
+	opts.system = strings.TrimSpace(opts.System)
+	if opts.SystemFile != "" {
+		if abs, err := filepath.Abs(opts.SystemFile); err == nil {
+			opts.SystemFile = abs
+		}
+		b, err := os.ReadFile(opts.SystemFile)
+		if err != nil {
+			return opts, nil, fmt.Errorf("--system-file: %w", err)
+		}
+		opts.system = strings.TrimSpace(string(b))
+	}
+	if _, ok := tokenizerFor(opts.Model); !ok {
+		return opts, nil, fmt.Errorf("invalid --model %q: want a known model or one of cl100k_base, o200k_base, claude or chars", opts.Model)
+	}
+	switch opts.TrimStrategy {
+	case "largest", "last", "truncate":
+	default:
+		return opts, nil, fmt.Errorf("invalid --trim-strategy %q: want largest, last or truncate", opts.TrimStrategy)
+	}
+	switch opts.PathBase {
+	case "absolute", "launch", "root", "repo":
+	default:
+		return opts, nil, fmt.Errorf("invalid --path-base %q: want absolute, launch, root or repo", opts.PathBase)
+	}
+	switch opts.BinaryMode {
+	case "placeholder", "omit", "base64", "hexdump":
+	default:
+		return opts, nil, fmt.Errorf("invalid --binary-mode %q: want placeholder, omit, base64 or hexdump", opts.BinaryMode)
+	}
+	if _, ok := providers[opts.Provider]; opts.Provider != "" && !ok {
+		return opts, nil, fmt.Errorf("invalid --provider %q: want openai, anthropic or ollama", opts.Provider)
+	}
+	if opts.ConfirmCost < 0 {
+		return opts, nil, fmt.Errorf("invalid --confirm-cost %g: want 0 or more", opts.ConfirmCost)
+	}
+	if opts.Staged && opts.ChangedSince != "" {
+		return opts, nil, fmt.Errorf("--staged and --changed-since can't be combined")
+	}
+	if opts.Staged {
+		if _, err := gitTopLevel(opts.Path); err != nil {
+			return opts, nil, fmt.Errorf("--staged: %w", err)
+		}
+	}
+	if opts.ChangedSince != "" {
+		if err := gitVerifyCommit(opts.Path, opts.ChangedSince); err != nil {
+			return opts, nil, fmt.Errorf("--changed-since: %w", err)
+		}
+	}
+	if opts.RepoInfo {
+		if _, err := gitTopLevel(opts.Path); err != nil {
+			return opts, nil, fmt.Errorf("--repo-info: %w", err)
+		}
+	}
+	if opts.Commits < 0 {
+		return opts, nil, fmt.Errorf("invalid --recent-commits %d: want 0 or more", opts.Commits)
+	}
+	if opts.Commits > 0 {
+		if _, err := gitTopLevel(opts.Path); err != nil {
+			return opts, nil, fmt.Errorf("--recent-commits: %w", err)
+		}
+	}
+	if opts.DiffRef != "" {
+		opts.Diff = true
+	}
+	if opts.Diff {
+		if err := gitVerifyCommit(opts.Path, opts.diffBase()); err != nil {
+			return opts, nil, fmt.Errorf("--diff: %w", err)
+		}
+	}
+	return opts, rest, nil
+}
+
+// parseInterspersed parses flags wherever they appear in args and returns
+// the other arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for fs.Parse(args); fs.NArg() > 0; fs.Parse(args) {
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	return rest
+}
+
+// diffBase is the commit --diff compares the working tree with.
+func (o options) diffBase() string {
+	if o.DiffRef != "" {
+		return o.DiffRef
+	}
+	return "HEAD"
+}
+
+// rootEnv names the environment variable that sets the default root, so
+// editor integrations and aliases can open a project without --path.
+const rootEnv = "CTX_TUI_ROOT"
+
+func defaultRoot() string {
+	if root := os.Getenv(rootEnv); root != "" {
+		return root
+	}
+	return "."
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+func (o *options) bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.Path, "path", defaultRoot(), "path to directory to open; defaults to $"+rootEnv+" when set")
+	fs.BoolVar(&o.RepoRoot, "repo-root", false, "open the top of the git repository containing --path instead")
+	fs.StringVar(&o.Explain, "explain", "", "write a reproducibility record of the selection to this file on accept")
+	fs.StringVar(&o.Output, "output", "", "also write the prompt to this file, replacing it atomically")
+	fs.StringVar(&o.Append, "append", "", "append the prompt to this file under a header, creating it if needed")
+	fs.StringVar(&o.Export, "export", "", "also write the prompt gzipped and base64 encoded to this file; read it back with ctx-tui decode")
+	fs.StringVar(&o.Pipe, "pipe", "", "on accept, run this shell command with the prompt on its stdin instead of copying it, e.g. \"llm -m gpt-4o\"")
+	fs.StringVar(&o.PipeOut, "pipe-output", "", "write the --pipe command's output to this file instead of the terminal")
+	fs.StringVar(&o.Control, "control", "", "watch this file for appended select <path>, deselect <path> and clear lines from other programs")
+	fs.BoolVar(&o.CaseSensitive, "case-sensitive", false, "start with case-sensitive filtering (toggle with ctrl+t)")
+	fs.BoolVar(&o.RequireRequest, "require-request", false, "refuse to copy while the request is empty")
+	fs.StringVar(&o.FromManifest, "from-manifest", "", "pre-select the files listed in a record written by --explain")
+	fs.StringVar(&o.Recipe, "recipe", "", "recreate the selection and options from a recipe string copied with R")
+	fs.BoolVar(&o.CopyOnAccept, "copy-on-accept", true, "copy the prompt to the clipboard when accepting; when false, enter prints it and c copies")
+	fs.BoolVar(&o.Copy, "copy", false, "copy the prompt to the clipboard on accept even with --stdout or --pipe")
+	fs.BoolVar(&o.Stdout, "stdout", false, "write the prompt to stdout instead of the clipboard, drawing the UI on the terminal so it can be piped")
+	fs.BoolVar(&o.NoWatch, "no-watch", false, "don't watch the tree for changes; press r to refresh")
+	fs.BoolVar(&o.SizeBars, "size-bars", false, "show each file's size relative to the largest loaded file")
+	fs.BoolVar(&o.Hints, "hints", true, "show the main keys for the focused pane in the footer (toggle with H)")
+	fs.BoolVar(&o.TreeTokens, "tree-tokens", false, "show each file's approximate token count in the tree (toggle with T)")
+	fs.BoolVar(&o.Hidden, "hidden", false, "show dotfiles and dot-directories in the tree (toggle with .)")
+	fs.BoolVar(&o.HideEmpty, "hide-empty", false, "hide directories that contain no files (toggle with E)")
+	fs.IntVar(&o.MaxDirEntries, "max-dir-entries", 10000, "list at most this many entries of a directory at a time; 0 for no limit")
+	fs.IntVar(&o.SearchWorkers, "search-workers", runtime.NumCPU(), "number of files searched concurrently by ctrl+f and ctrl+p")
+	fs.StringVar(&o.ClipboardSelection, "clipboard-selection", "clipboard", "X11 selection to copy into: clipboard or primary")
+	fs.StringVar(&o.Clipboard, "clipboard", "auto", "how to copy: auto (a clipboard utility), osc52 (through the terminal, e.g. over SSH) or tmux (into its paste buffer)")
+	fs.StringVar(&o.OSC52Passthrough, "osc52-passthrough", "auto", "wrap OSC 52 for a multiplexer: auto, none, tmux or screen")
+	fs.StringVar(&o.Theme, "theme", "default", "color theme: default or high-contrast")
+	fs.StringVar(&o.Background, "background", "auto", "terminal background the theme assumes: auto, dark or light")
+	fs.IntVar(&o.TextareaHeight, "textarea-height", 0, "height of the request box in lines; 0 fills the pane (resize with ctrl+up/down)")
+	fs.DurationVar(&o.StatusDuration, "status-duration", 2*time.Second, "how long status messages stay in the footer")
+	fs.BoolVar(&o.FullTree, "full-tree", false, "emit every loaded file in the tree section, not just the selection")
+	fs.BoolVar(&o.NoTree, "no-tree", false, "leave the <file_tree> block out of the prompt")
+	fs.BoolVar(&o.NotebookCells, "notebook-cells", false, "emit only the code and markdown cells of .ipynb files")
+	fs.BoolVar(&o.Summary, "summary", false, "emit a <context_summary> block counting selected files by type")
+	fs.BoolVar(&o.FileMode, "file-mode", false, "emit each file's permission bits in its metadata")
+	fs.BoolVar(&o.FileTokens, "file-tokens", false, "emit each file's estimated token count in its metadata")
+	fs.BoolVar(&o.Dedupe, "dedupe", false, "emit files that differ only in whitespace once, noting the others")
+	fs.BoolVar(&o.BundleMarked, "bundle-marked", false, "emit every file under a directory marked with m as one <directory_bundle> block")
+	fs.BoolVar(&o.Guidance, "guidance", false, "emit a root-level AGENTS.md or CLAUDE.md first, as <project_guidance>")
+	fs.BoolVar(&o.RepoInfo, "repo-info", false, "emit a <repo_info> block with the git remote, branch, HEAD commit and whether there are uncommitted changes")
+	fs.IntVar(&o.Commits, "recent-commits", 0, "emit a <recent_commits> block with this many of the latest commits touching the root")
+	fs.StringVar(&o.CommitFormat, "commit-format", "%h %ad %s", "git log --format for --recent-commits, e.g. \"%h %an %s\" or oneline")
+	fs.BoolVar(&o.Diff, "diff", false, "emit a <git_diff> block of the changes to tracked files under the root since HEAD, staged or not")
+	fs.StringVar(&o.DiffRef, "diff-ref", "", "diff against this commit or branch instead of HEAD, e.g. main; implies --diff")
+	fs.BoolVar(&o.Fence, "fence", false, "wrap the whole prompt in one code fence, longer than any fence inside it")
+	fs.BoolVar(&o.ScopeNote, "scope-note", false, "start the request with a note of how many files and roughly how many tokens of context precede it")
+	fs.StringVar(&o.Format, "format", "xml", "prompt format: xml, markdown, repomix (its plain layout), or json for scripts")
+	fs.StringVar(&o.Template, "template", "", "render the prompt with this Go text/template file instead of --format; it sees .Tree, .Files (.Path, .Content, .Language, .Tokens), .Request and more")
+	fs.StringVar(&o.System, "system", "", "system prompt put at the top of the prompt, or sent as the system message by s")
+	fs.StringVar(&o.SystemFile, "system-file", "", "read the --system prompt from this file, relative to the project in the config")
+	fs.StringVar(&o.PathBase, "path-base", "absolute", "how file paths are written: absolute, or relative to launch (the working directory), root or repo (the git top)")
+	fs.Var(&o.MaxFileSize, "max-file-size", "truncate emitted files to this many bytes, e.g. 256kb, marking larger files with ✂ in the tree; 0 for no limit")
+	fs.Var(&o.MaxFileSizes, "max-size-for", "per-extension --max-file-size override, e.g. json=100K; repeatable")
+	fs.Var(&o.ChunkSize, "chunk-size", "with C in the review, copy the prompt in chunks of up to this many bytes, split between files; 0 for one file per chunk")
+	fs.StringVar(&o.Model, "model", "cl100k_base", "model whose tokenizer counts tokens, e.g. gpt-4o or claude-sonnet-4, or a tokenizer: cl100k_base, o200k_base, claude or chars")
+	fs.StringVar(&o.SendModel, "send-model", "", "model s sends the prompt to from the review (pick another with m); defaults to --model, or the provider's default when that names a tokenizer")
+	fs.StringVar(&o.Provider, "provider", "", "API s sends to: openai, anthropic, or ollama for a local server; by default anthropic for claude models and openai for the rest")
+	fs.StringVar(&o.APIBase, "api-base", "", "base URL of the API s sends to, e.g. an OpenAI-compatible server or a remote Ollama host; defaults to the provider's, and for ollama to $OLLAMA_HOST")
+	fs.StringVar(&o.APIKeyEnv, "api-key-env", "", "environment variable holding the API key; defaults to OPENAI_API_KEY or ANTHROPIC_API_KEY")
+	fs.Float64Var(&o.ConfirmCost, "confirm-cost", 0.5, "ask before sending a prompt whose input is estimated to cost more than this many dollars; 0 to never ask")
+	fs.BoolVar(&o.NoTranscripts, "no-transcripts", false, "don't save each answer and its question as Markdown under "+transcriptsDir+" in the root")
+	fs.BoolVar(&o.RefreshFiles, "refresh-files", false, "send the selected files again, as they are now, with each follow-up question (toggle with ctrl+o while writing one)")
+	fs.IntVar(&o.Budget, "budget", 0, "token budget for the prompt; the count turns red when it is exceeded")
+	fs.BoolVar(&o.StrictBudget, "strict-budget", false, "refuse to copy while the prompt is over --budget")
+	fs.StringVar(&o.TrimStrategy, "trim-strategy", "largest", "how B trims the selection to --budget: largest (drop the biggest files first), last (drop the last emitted first) or truncate (cut the biggest file to fit)")
+	fs.StringVar(&o.BinaryMode, "binary-mode", "placeholder", "how binary files are emitted: placeholder, omit, base64 or hexdump")
+	fs.IntVar(&o.BinaryBytes, "binary-bytes", 64, "bytes of each binary file shown by --binary-mode base64 and hexdump")
+	fs.Var(&o.First, "first", "glob of selected files to emit before all others, e.g. README*; repeatable, earlier globs win")
+	fs.StringVar(&o.ChangedSince, "changed-since", "", "show and select only the files changed since the current branch forked from this ref, e.g. origin/main, including uncommitted and untracked files")
+	fs.BoolVar(&o.Staged, "staged", false, "show and select only the files with staged changes, and limit --diff to what is staged")
+	fs.Var(&o.Select, "select", "glob of files to select at startup, e.g. src/**/*.go; repeatable")
+	fs.Var(&o.Include, "include", "glob of files to show in the tree, e.g. **/*.go; repeatable, and other files are hidden")
+	fs.Var(&o.Exclude, "exclude", "gitignore-style pattern of paths to leave out of the tree, e.g. **/*_test.go; repeatable")
+	fs.BoolVar(&o.ShowIgnored, "show-ignored", false, "keep ignored and excluded entries in the tree, dimmed and unselectable (toggle with i)")
+	fs.BoolVar(&o.NoDefaultExcludes, "no-default-excludes", false, "show dependency and build directories such as node_modules, vendor and dist, which are left out by default")
+	fs.BoolVar(&o.NoGitignore, "no-gitignore", false, "show files ignored by .gitignore; otherwise each repository in the tree, submodules included, follows its own .gitignore files")
+	fs.BoolVar(&o.NoAutoSelect, "no-auto-select", false, "ignore the select globs from config and flags")
+	fs.Var(&o.Commands, "cmd", "shell command whose output is included in the prompt; repeatable")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+)
+
+// screenChunkSize is how much of an escape sequence GNU screen passes
+// through in one DCS string.
+const screenChunkSize = 76
+
+// osc52Sequence returns the OSC 52 escape sequence that sets the terminal
+// emulator's clipboard (or primary selection) to text. passthrough wraps
+// it for a multiplexer: "tmux" in a single DCS string, "screen" in a
+// series of short ones; anything else leaves it bare.
+func osc52Sequence(text, selection, passthrough string) string {
+	target := "c"
+	if selection == "primary" {
+		target = "p"
+	}
+	seq := "\x1b]52;" + target + ";" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	switch passthrough {
+	case "tmux":
+		return "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	case "screen":
+		var sb strings.Builder
+		for len(seq) > 0 {
+			n := min(len(seq), screenChunkSize)
+			sb.WriteString("\x1bP" + seq[:n] + "\x1b\\")
+			seq = seq[n:]
+		}
+		return sb.String()
+	}
+	return seq
+}
+
+// detectPassthrough picks the multiplexer wrapping for "auto" from the
+// environment.
+func detectPassthrough(mode string) string {
+	if mode != "auto" {
+		return mode
+	}
+	switch {
+	case os.Getenv("TMUX") != "":
+		return "tmux"
+	case os.Getenv("STY") != "":
+		return "screen"
+	}
+	return "none"
+}
+
+// copyOSC52 sends text to the local clipboard through the terminal, which
+// works over SSH. It writes to the controlling terminal so it still works
+// when standard output is redirected.
+func copyOSC52(text string, opts options) error {
+	var w io.Writer = os.Stdout
+	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
+		defer tty.Close()
+		w = tty
+	}
+	_, err := io.WriteString(w, osc52Sequence(text, opts.ClipboardSelection, detectPassthrough(opts.OSC52Passthrough)))
+	return err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeFileAtomic writes data to path through a temporary file in the same
+// directory that is renamed into place, so readers never see a partial
+// file. An existing file keeps its mode; a new one gets 0644 less the
+// umask.
+func writeFileAtomic(path string, data []byte) error {
+	info, statErr := os.Stat(path)
+	tmp, err := createTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp", 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if statErr == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// createTemp is os.CreateTemp with a mode, which the umask applies to.
+func createTemp(dir, prefix string, perm os.FileMode) (*os.File, error) {
+	for {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(rand.Uint64(), 36))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+}
+
+// appendPrompt appends prompt to the file at path under a timestamped
+// header, creating the file if needed.
+func appendPrompt(path, prompt string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	sep := ""
+	if info.Size() > 0 {
+		sep = "\n"
+	}
+	header := fmt.Sprintf("%s===== ctx-tui prompt %s =====\n", sep, time.Now().Format(time.RFC3339))
+	if _, err := f.WriteString(header + prompt + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// fence wraps s in one code fence longer than any run of backticks inside
+// it, so inner fences stay literal and the whole prompt pastes as a block.
+func fence(s string) string {
+	f := fenceFor(s)
+	return f + "\n" + s + "\n" + f
+}
+
+// fenceFor returns a backtick fence that can enclose s.
+func fenceFor(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// sink is somewhere an accepted prompt goes; action describes it in errors.
+type sink struct {
+	action string
+	write  func(prompt string) error
+}
+
+// promptSinks lists every destination configured for the accepted prompt,
+// so it is generated once and fanned out: the clipboard when clip is set,
+// stdout with --stdout or when nothing else consumes it, then the files,
+// and --pipe last since it may run for a while.
+func promptSinks(opts options, clip bool, dir string) []sink {
+	var sinks []sink
+	if clip {
+		sinks = append(sinks, sink{"copying prompt", func(p string) error { return copyToClipboard(p, opts) }})
+	}
+	if opts.Stdout || !clip && opts.Pipe == "" && opts.Output == "" && opts.Append == "" && opts.Export == "" {
+		sinks = append(sinks, sink{"printing prompt", func(p string) error {
+			_, err := fmt.Println(p)
+			return err
+		}})
+	}
+	if opts.Output != "" {
+		sinks = append(sinks, sink{"writing prompt", func(p string) error { return writeFileAtomic(opts.Output, []byte(p+"\n")) }})
+	}
+	if opts.Append != "" {
+		sinks = append(sinks, sink{"appending prompt", func(p string) error { return appendPrompt(opts.Append, p) }})
+	}
+	if opts.Export != "" {
+		sinks = append(sinks, sink{"exporting prompt", func(p string) error { return writeExport(opts.Export, p) }})
+	}
+	if opts.Pipe != "" {
+		sinks = append(sinks, sink{"piping prompt", func(p string) error { return pipePrompt(dir, opts.Pipe, p, opts.PipeOut) }})
+	}
+	return sinks
+}
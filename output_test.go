@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "private.txt")
+	if err := os.WriteFile(existing, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(existing, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(existing, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(existing); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("existing file: mode = %v, err = %v; want 0600 kept", info.Mode().Perm(), err)
+	}
+
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+	created := filepath.Join(dir, "new.txt")
+	if err := writeFileAtomic(created, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(created); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("new file: mode = %v, err = %v; want 0644 less the umask 077", info.Mode().Perm(), err)
+	}
+	if b, _ := os.ReadFile(created); string(b) != "new" {
+		t.Errorf("content = %q, want new", b)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)`)
+
+// hunk is one @@ section of a unified diff. Its lines keep their ' ', '-'
+// or '+' prefix.
+type hunk struct {
+	oldStart int
+	lines    []string
+	accepted bool
+}
+
+// filePatch is the diff of one file. oldPath is empty for a new file and
+// path for a deleted one; they differ for a rename.
+type filePatch struct {
+	oldPath, path string
+	hunks         []hunk
+}
+
+// name is the path the patch is shown under.
+func (p filePatch) name() string {
+	if p.path == "" {
+		return p.oldPath
+	}
+	return p.path
+}
+
+// parsePatches reads the unified diffs in the fenced diff and patch blocks
+// of a model's answer.
+func parsePatches(text string) []filePatch {
+	var patches []filePatch
+	for _, block := range diffBlocks(text) {
+		patches = append(patches, parseDiff(block)...)
+	}
+	return patches
+}
+
+// diffBlocks returns the lines of each ```diff or ```patch block in text.
+func diffBlocks(text string) [][]string {
+	var blocks [][]string
+	var fence string
+	var inDiff bool
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence == "" {
+			if !strings.HasPrefix(trimmed, "```") && !strings.HasPrefix(trimmed, "~~~") {
+				continue
+			}
+			rest := strings.TrimLeft(trimmed, trimmed[:1])
+			fence = trimmed[:len(trimmed)-len(rest)]
+			lang, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+			inDiff = lang == "diff" || lang == "patch"
+			if inDiff {
+				blocks = append(blocks, nil)
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			fence = ""
+			continue
+		}
+		if inDiff {
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], line)
+		}
+	}
+	return blocks
+}
+
+// parseDiff splits a unified diff into file patches, leniently: hunk line
+// counts are ignored since models often get them wrong.
+func parseDiff(lines []string) []filePatch {
+	var patches []filePatch
+	var h *hunk
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			patches = append(patches, filePatch{oldPath: diffPath(line[4:]), path: diffPath(lines[i+1][4:])})
+			h = nil
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if len(patches) == 0 {
+				continue
+			}
+			p := &patches[len(patches)-1]
+			start := 0
+			if m := hunkHeader.FindStringSubmatch(line); m != nil {
+				start, _ = strconv.Atoi(m[1])
+			}
+			p.hunks = append(p.hunks, hunk{oldStart: start, accepted: true})
+			h = &p.hunks[len(p.hunks)-1]
+		case h == nil:
+			// "diff --git", "index" and other headers
+		case line == "":
+			// models often drop the space of an empty context line
+			h.lines = append(h.lines, " ")
+		case strings.ContainsRune(" +-", rune(line[0])):
+			h.lines = append(h.lines, line)
+		}
+	}
+	for i := range patches {
+		for j := range patches[i].hunks {
+			// trailing context only anchors the hunk, and a blank one is
+			// often just the gap before the closing fence
+			l := patches[i].hunks[j].lines
+			for len(l) > 0 && strings.TrimSpace(l[len(l)-1]) == "" {
+				l = l[:len(l)-1]
+			}
+			patches[i].hunks[j].lines = l
+		}
+	}
+	return slices.DeleteFunc(patches, func(p filePatch) bool {
+		return len(p.hunks) == 0 || p.name() == ""
+	})
+}
+
+// diffPath reads the path of a ---/+++ line, dropping git's a/ and b/
+// prefixes and any timestamp. /dev/null reads as empty.
+func diffPath(s string) string {
+	s, _, _ = strings.Cut(s, "\t")
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(s, "a/"); ok {
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(s, "b/"); ok {
+		return rest
+	}
+	return s
+}
+
+// applyHunks applies the accepted hunks to content and reports how many
+// there were. Each hunk is looked for at the line it names first and then
+// ever further away, ignoring trailing whitespace.
+func applyHunks(content string, hunks []hunk) (string, int, error) {
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+	applied, shift := 0, 0
+	for i, h := range hunks {
+		if !h.accepted {
+			continue
+		}
+		var old, repl []string
+		for _, l := range h.lines {
+			switch l[0] {
+			case ' ':
+				old, repl = append(old, l[1:]), append(repl, l[1:])
+			case '-':
+				old = append(old, l[1:])
+			case '+':
+				repl = append(repl, l[1:])
+			}
+		}
+		at := findLines(lines, old, h.oldStart-1+shift)
+		if at < 0 {
+			return "", 0, fmt.Errorf("hunk %d doesn't match the file", i+1)
+		}
+		lines = slices.Concat(lines[:at], repl, lines[at+len(old):])
+		shift += len(repl) - len(old)
+		applied++
+	}
+	if len(lines) == 0 {
+		return "", applied, nil
+	}
+	return strings.Join(lines, "\n") + "\n", applied, nil
+}
+
+// findLines returns where want occurs in lines nearest to hint, or -1.
+func findLines(lines, want []string, hint int) int {
+	last := len(lines) - len(want)
+	if last < 0 {
+		return -1
+	}
+	hint = min(max(hint, 0), last)
+	matches := func(at int) bool {
+		for i, w := range want {
+			if strings.TrimRight(lines[at+i], " \t\r") != strings.TrimRight(w, " \t\r") {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; hint-d >= 0 || hint+d <= last; d++ {
+		if hint-d >= 0 && matches(hint-d) {
+			return hint - d
+		}
+		if hint+d <= last && matches(hint+d) {
+			return hint + d
+		}
+	}
+	return -1
+}
+
+// resolvePatchPath finds the file a diff names: an absolute path as it
+// is, a relative one against the directory --path-base wrote the prompt's
+// paths relative to. Paths leaving root, also through a symlink, are
+// refused.
+func resolvePatchPath(root, base, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	path := filepath.FromSlash(name)
+	if !filepath.IsAbs(path) {
+		dir := pathBaseDir(root, base)
+		if dir == "" {
+			dir = root
+		}
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+	if !isWithin(root, path) {
+		return "", errors.New("outside the tree")
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	real, err := realPath(path)
+	if err != nil {
+		return "", err
+	}
+	if !isWithin(realRoot, real) {
+		return "", errors.New("a symlink leads outside the tree")
+	}
+	return path, nil
+}
+
+// realPath resolves the symlinks in p, or for a file yet to be created
+// in its nearest existing parent.
+func realPath(p string) (string, error) {
+	rest := ""
+	for {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		parent := filepath.Dir(p)
+		if !errors.Is(err, fs.ErrNotExist) || parent == p {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// apply writes the patch's accepted hunks to the files under root and
+// reports how many were applied. base is the --path-base the prompt was
+// written with.
+func (p filePatch) apply(root, base string) (int, error) {
+	source, err := resolvePatchPath(root, base, p.oldPath)
+	if err != nil {
+		return 0, err
+	}
+	target, err := resolvePatchPath(root, base, p.path)
+	if err != nil {
+		return 0, err
+	}
+	var content []byte
+	mode := os.FileMode(0o644)
+	if source != "" {
+		info, err := os.Stat(source)
+		if err != nil {
+			return 0, err
+		}
+		mode = info.Mode().Perm()
+		if content, err = os.ReadFile(source); err != nil {
+			return 0, err
+		}
+	} else if _, err := os.Stat(target); err == nil {
+		return 0, errors.New("already exists")
+	}
+	out, n, err := applyHunks(string(content), p.hunks)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	if target == "" {
+		if out != "" || n < len(p.hunks) {
+			return 0, errors.New("only part of the file would be deleted")
+		}
+		return n, os.Remove(source)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(target, []byte(out), mode); err != nil {
+		return 0, err
+	}
+	if source != "" && source != target {
+		return n, os.Remove(source)
+	}
+	return n, nil
+}
+
+// patchReview is the state of the patch pane, which previews the diffs
+// in an answer one file at a time.
+type patchReview struct {
+	patches []filePatch
+	file    int
+	hunk    int
+}
+
+// openPatches previews the diffs in the answer so their hunks can be
+// picked and applied.
+func (m *model) openPatches() tea.Cmd {
+	patches := parsePatches(m.response.text)
+	if len(patches) == 0 {
+		return m.flash("No diffs in the answer")
+	}
+	m.patch = patchReview{patches: patches}
+	m.focus = patchView
+	m.renderPatch()
+	return nil
+}
+
+// renderPatch shows the current file's hunks, marking accepted ones, and
+// scrolls to the current hunk.
+func (m *model) renderPatch() {
+	p := m.patch.patches[m.patch.file]
+	var sb strings.Builder
+	top := 0
+	for i, h := range p.hunks {
+		if i == m.patch.hunk {
+			top = strings.Count(sb.String(), "\n")
+		}
+		mark := "[ ]"
+		if h.accepted {
+			mark = "[x]"
+		}
+		header := fmt.Sprintf("%s @@ -%d @@", mark, h.oldStart)
+		if i == m.patch.hunk {
+			header = cursorStyle.Render(header)
+		}
+		sb.WriteString(header + "\n")
+		for _, l := range h.lines {
+			switch l[0] {
+			case '+':
+				l = gutterStyles[markAdded].Render(l)
+			case '-':
+				l = gutterStyles[markRemoved].Render(l)
+			}
+			sb.WriteString(strings.ReplaceAll(l, "\t", "    ") + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	m.viewport.SetContent(sb.String())
+	m.viewport.SetYOffset(top)
+}
+
+// patchHeading titles the patch pane with the current file and hunk.
+func (m model) patchHeading() string {
+	p := m.patch.patches[m.patch.file]
+	kind := ""
+	switch {
+	case p.oldPath == "":
+		kind = " [new]"
+	case p.path == "":
+		kind = " [deleted]"
+	case p.oldPath != p.path:
+		kind = " [renamed from " + p.oldPath + "]"
+	}
+	return fmt.Sprintf("Patch %d/%d: %s%s (hunk %d/%d)", m.patch.file+1, len(m.patch.patches), p.name(), kind, m.patch.hunk+1, len(p.hunks))
+}
+
+// stepHunk moves to the next or previous hunk, crossing into the
+// neighbouring file at either end.
+func (m *model) stepHunk(delta int) {
+	r := &m.patch
+	r.hunk += delta
+	switch {
+	case r.hunk < 0 && r.file > 0:
+		r.file--
+		r.hunk = len(r.patches[r.file].hunks) - 1
+	case r.hunk >= len(r.patches[r.file].hunks) && r.file < len(r.patches)-1:
+		r.file++
+		r.hunk = 0
+	}
+	r.hunk = min(max(r.hunk, 0), len(r.patches[r.file].hunks)-1)
+	m.renderPatch()
+}
+
+// applyPatches writes the accepted hunks to disk and reports what was
+// applied and what failed.
+func (m *model) applyPatches() tea.Cmd {
+	var failed []string
+	hunks, files := 0, 0
+	for _, p := range m.patch.patches {
+		n, err := p.apply(m.root.path, m.opts.PathBase)
+		if err != nil {
+			failed = append(failed, p.name()+": "+err.Error())
+			continue
+		}
+		if n > 0 {
+			hunks += n
+			files++
+		}
+	}
+	m.focus = responseView
+	m.renderResponse()
+	refreshTree(m.root, m.watcher)
+	m.reflatten()
+	m.previewPath = ""
+	if len(failed) > 0 {
+		m.warning = "Not applied: " + strings.Join(failed, "; ")
+	}
+	return tea.Batch(m.flash(fmt.Sprintf("Applied %d hunks to %d files", hunks, files)), m.refreshGitStatus())
+}
+
+// updatePatch handles a key in the patch pane.
+func (m *model) updatePatch(key string) tea.Cmd {
+	p := &m.patch.patches[m.patch.file]
+	switch key {
+	case "esc", "tab":
+		m.focus = responseView
+		m.renderResponse()
+	case " ":
+		h := &p.hunks[m.patch.hunk]
+		h.accepted = !h.accepted
+		m.renderPatch()
+	case "a":
+		// accept the whole file unless it already is
+		all := !slices.ContainsFunc(p.hunks, func(h hunk) bool { return !h.accepted })
+		for i := range p.hunks {
+			p.hunks[i].accepted = !all
+		}
+		m.renderPatch()
+	case "n":
+		m.stepHunk(1)
+	case "N":
+		m.stepHunk(-1)
+	case "enter":
+		return m.applyPatches()
+	case "up", "k":
+		m.viewport.LineUp(1)
+	case "down", "j":
+		m.viewport.LineDown(1)
+	case "pgup":
+		m.viewport.PageUp()
+	case "pgdown":
+		m.viewport.PageDown()
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// loadPins returns the paths pinned for the project at root, relative to
+// it and in pin order, or nil if there are none.
+func loadPins(root string) []string {
+	path, err := projectStatePath("pins", root)
+	if err != nil {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var pins []string
+	if json.Unmarshal(b, &pins) != nil {
+		return nil
+	}
+	return pins
+}
+
+// savePins keeps pins for the project at root as a JSON array in the user
+// config dir, removing the file when there are none.
+func savePins(root string, pins []string) error {
+	path, err := projectStatePath("pins", root)
+	if err != nil {
+		return err
+	}
+	if len(pins) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// applyPins flags the nodes of the saved pins, loading their directories
+// as needed, and drops pins that no longer exist.
+func (m *model) applyPins(pins []string) {
+	for _, rel := range pins {
+		if n := lookupPath(m.root, filepath.Join(m.root.path, filepath.FromSlash(rel)), m.watcher, false); n != nil && n != m.root {
+			n.pinned = true
+			m.pins = append(m.pins, rel)
+		}
+	}
+}
+
+// togglePin pins or unpins n and saves the pin set.
+func (m *model) togglePin(n *node) error {
+	rel, err := filepath.Rel(m.root.path, n.path)
+	if err != nil || rel == "." {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	n.pinned = !n.pinned
+	if n.pinned {
+		m.pins = append(m.pins, rel)
+	} else {
+		m.pins = slices.DeleteFunc(m.pins, func(p string) bool { return p == rel })
+	}
+	return savePins(m.root.path, m.pins)
+}
+
+// pinnedItems lists the pinned nodes, labelled with their relative paths.
+func (m *model) pinnedItems() []list.Item {
+	var items []list.Item
+	for _, rel := range m.pins {
+		n := lookupPath(m.root, filepath.Join(m.root.path, filepath.FromSlash(rel)), m.watcher, false)
+		if n == nil || m.hidden(n) {
+			continue
+		}
+		items = append(items, item{node: n, label: rel, pinned: true})
+	}
+	return items
+}
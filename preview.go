@@ -0,0 +1,232 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	gutterStyles = map[diffMarker]lipgloss.Style{
+		markAdded:    lipgloss.NewStyle().Foreground(lipgloss.Color("2")),
+		markRemoved:  lipgloss.NewStyle().Foreground(lipgloss.Color("1")),
+		markModified: lipgloss.NewStyle().Foreground(lipgloss.Color("3")),
+	}
+	rangeStyle = lipgloss.NewStyle().Reverse(true)
+)
+
+const previewLimit = 256 << 10
+
+// previewContent reads a file or directory listing for the right pane. text
+// reports whether the content is file text whose lines can be selected.
+func previewContent(n *node) (content string, text, truncated bool) {
+	if n.isDir {
+		entries, err := os.ReadDir(n.path)
+		if err != nil {
+			return "[" + err.Error() + "]", false, false
+		}
+		var sb strings.Builder
+		for _, e := range entries {
+			sb.WriteString(e.Name())
+			if e.IsDir() {
+				sb.WriteString("/")
+			}
+			sb.WriteString("\n")
+		}
+		return sb.String(), false, false
+	}
+	f, err := os.Open(n.path)
+	if err != nil {
+		return "[" + err.Error() + "]", false, false
+	}
+	defer f.Close()
+	buf := make([]byte, previewLimit+1)
+	read, _ := f.Read(buf)
+	b := buf[:read]
+	if strings.Contains(string(b), "\x00") {
+		return "[Binary file]", false, false
+	}
+	truncated = len(b) > previewLimit
+	if truncated {
+		b = b[:previewLimit]
+	}
+	return strings.ReplaceAll(string(b), "\t", "    "), true, truncated
+}
+
+// renderPreview decorates file text with git diff markers and highlights
+// the node's selected line range.
+func renderPreview(n *node, content string, truncated bool, markers map[int]diffMarker) string {
+	lines := strings.Split(content, "\n")
+	for i, l := range lines {
+		if n.lines.contains(i + 1) {
+			l = rangeStyle.Render(l)
+		}
+		if len(markers) > 0 {
+			gutter := "  "
+			if mk, ok := markers[i+1]; ok {
+				gutter = gutterStyles[mk].Render(string(mk)) + " "
+			}
+			l = gutter + l
+		}
+		lines[i] = l
+	}
+	s := strings.Join(lines, "\n")
+	if truncated {
+		s += "\n[... preview truncated]"
+	}
+	return s
+}
+
+// syncPreview loads the highlighted node into the viewport when the
+// preview pane is showing and the highlight has moved.
+func (m *model) syncPreview() tea.Cmd {
+	if !m.showPreview || m.focus != fileTreeView {
+		return nil
+	}
+	sel, ok := m.list.SelectedItem().(item)
+	if !ok {
+		m.previewPath = ""
+		m.viewport.SetContent("")
+		return nil
+	}
+	if sel.node.path == m.previewPath {
+		return nil
+	}
+	m.previewPath = sel.node.path
+	m.rangeAnchor = 0
+	cmd := m.renderPreviewPane(sel.node)
+	m.viewport.GotoTop()
+	return cmd
+}
+
+// renderPreviewPane shows n in the viewport with the diff markers cached
+// for it, returning a command to diff it again if they are missing or stale.
+func (m *model) renderPreviewPane(n *node) tea.Cmd {
+	content, text, truncated := previewContent(n)
+	m.previewText = text
+	m.previewLines = strings.Count(content, "\n") + 1
+	var cmd tea.Cmd
+	if text {
+		var markers map[int]diffMarker
+		markers, cmd = m.previewMarkers(n.path)
+		content = renderPreview(n, content, truncated, markers)
+	}
+	m.viewport.SetContent(content)
+	return cmd
+}
+
+// diffMarkers are a file's git diff markers as of its modification time
+// and size.
+type diffMarkers struct {
+	modTime time.Time
+	size    int64
+	markers map[int]diffMarker
+	pending bool
+}
+
+type diffMarkersMsg struct {
+	path string
+	diffMarkers
+}
+
+// previewMarkers returns the cached diff markers of path, which may be
+// stale, and a command that diffs it in the background when they are.
+func (m *model) previewMarkers(path string) (map[int]diffMarker, tea.Cmd) {
+	cached := m.diffMarkers[path]
+	info, err := os.Stat(path)
+	if err != nil || cached.pending || cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.markers, nil
+	}
+	cached.pending = true
+	m.diffMarkers[path] = cached
+	fresh := diffMarkers{modTime: info.ModTime(), size: info.Size()}
+	return cached.markers, func() tea.Msg {
+		fresh.markers = gitDiffMarkers(path)
+		return diffMarkersMsg{path: path, diffMarkers: fresh}
+	}
+}
+
+// handleDiffMarkers caches markers that arrived and redraws the preview
+// if it shows their file.
+func (m *model) handleDiffMarkers(msg diffMarkersMsg) tea.Cmd {
+	m.diffMarkers[msg.path] = msg.diffMarkers
+	if msg.path != m.previewPath || !m.showPreview || m.focus != fileTreeView {
+		return nil
+	}
+	sel, ok := m.list.SelectedItem().(item)
+	if !ok || sel.node.path != msg.path {
+		return nil
+	}
+	offset := m.viewport.YOffset
+	cmd := m.renderPreviewPane(sel.node)
+	m.viewport.SetYOffset(offset)
+	return cmd
+}
+
+// extendRange grows or shrinks the previewed file's line range by delta,
+// starting a new range at the top visible line when none is being marked.
+// The range is recorded on the node, which is selected so it is emitted.
+func (m *model) extendRange(delta int) tea.Cmd {
+	sel, ok := m.list.SelectedItem().(item)
+	if !ok || !m.previewText || sel.node.path != m.previewPath {
+		return nil
+	}
+	n := sel.node
+	if m.rangeAnchor == 0 {
+		m.rangeAnchor = m.viewport.YOffset + 1
+		m.rangeCursor = m.rangeAnchor
+	} else {
+		m.rangeCursor = min(max(m.rangeCursor+delta, 1), m.previewLines)
+	}
+	n.lines = lineRange{start: min(m.rangeAnchor, m.rangeCursor), end: max(m.rangeAnchor, m.rangeCursor)}
+	n.selected = true
+	// keep the moving end of the range on screen
+	if m.rangeCursor-1 < m.viewport.YOffset {
+		m.viewport.SetYOffset(m.rangeCursor - 1)
+	} else if m.rangeCursor > m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(m.rangeCursor - m.viewport.Height)
+	}
+	offset := m.viewport.YOffset
+	cmd := m.renderPreviewPane(n)
+	m.viewport.SetYOffset(offset)
+	return cmd
+}
+
+// clearRange drops the highlighted file's line range so the whole file is
+// emitted again.
+func (m *model) clearRange() tea.Cmd {
+	sel, ok := m.list.SelectedItem().(item)
+	if !ok {
+		return nil
+	}
+	sel.node.lines = lineRange{}
+	m.rangeAnchor = 0
+	if sel.node.path != m.previewPath {
+		return nil
+	}
+	offset := m.viewport.YOffset
+	cmd := m.renderPreviewPane(sel.node)
+	m.viewport.SetYOffset(offset)
+	return cmd
+}
+
+// scrollPane scrolls the right-hand viewport without touching the tree
+// cursor or the textarea.
+func (m *model) scrollPane(key string) bool {
+	switch key {
+	case "ctrl+pgup":
+		m.viewport.PageUp()
+	case "ctrl+pgdown":
+		m.viewport.PageDown()
+	case "ctrl+up":
+		m.viewport.LineUp(1)
+	case "ctrl+down":
+		m.viewport.LineDown(1)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func (m model) generatePrompt() string {
+	return buildPrompt(m.root, selectedFiles(m.root), m.textarea.Value(), m.promptOptions())
+}
+
+// buildPrompt renders the tree of root's selection, the contents of files,
+// and the user request in the --format chosen. It is shared by the TUI and
+// the CLI subcommands.
+func buildPrompt(root *node, files []string, request string, opts options) string {
+	return renderPrompt(gatherPrompt(root, files, request, opts))
+}
+
+// renderPrompt renders d in its --format or --template.
+func renderPrompt(d promptData) string {
+	opts := d.opts
+	render := formats[opts.Format]
+	switch {
+	case opts.tmpl != nil:
+		render = renderTemplate
+	case render == nil:
+		render = renderXML
+	}
+	if opts.ScopeNote {
+		req := d.request
+		d.request = ""
+		d.request = fmt.Sprintf("(context: %d files, ~%s tokens)\n", len(d.files), formatCount(opts.countTokens(render(d)))) + req
+	}
+	out := render(d)
+	if opts.Fence {
+		return fence(out)
+	}
+	return out
+}
+
+// promptData is what a prompt is made of, gathered once and then rendered
+// by a format. File paths are already in their emitted form.
+type promptData struct {
+	system   string
+	guidance []promptFile
+	summary  string
+	repo     *repoInfo
+	tree     string
+	files    []promptFile
+	bundles  []promptBundle
+	commands []commandResult
+	diff     *diffResult
+	commits  string
+	request  string
+	opts     options
+}
+
+// diffResult is the --diff of the working tree, or with staged of the
+// index, against base.
+type diffResult struct {
+	base   string
+	staged bool
+	diff   string
+	err    error
+}
+
+// promptBundle is a directory emitted as one block; its file paths are
+// relative to it.
+type promptBundle struct {
+	path  string
+	files []promptFile
+}
+
+func gatherPrompt(root *node, files []string, request string, opts options) promptData {
+	d := promptData{system: opts.system, request: request, opts: opts}
+	var bundles []*node
+	if opts.BundleMarked {
+		bundles = markedDirs(root)
+		files = slices.DeleteFunc(slices.Clone(files), func(p string) bool {
+			return slices.ContainsFunc(bundles, func(d *node) bool { return isWithin(d.path, p) })
+		})
+	}
+	var guidance []string
+	if opts.Guidance {
+		guidance = guidanceFiles(root.path)
+		files = slices.DeleteFunc(slices.Clone(files), func(p string) bool { return slices.Contains(guidance, p) })
+	}
+	files = orderFiles(root.path, files, opts.First)
+	show := pathFormatter(root.path, opts.PathBase)
+	d.files = collectFiles(root, files, opts)
+	if opts.Dedupe {
+		d.files = dedupeFiles(d.files, show)
+	}
+	for i := range d.files {
+		d.files[i].path = show(d.files[i].path)
+	}
+	d.guidance = collectFiles(root, guidance, opts)
+	for i := range d.guidance {
+		d.guidance[i].path = show(d.guidance[i].path)
+	}
+	if opts.Summary {
+		d.summary = extensionSummary(files)
+	}
+	if opts.RepoInfo {
+		if info, err := gitRepoInfo(root.path); err == nil {
+			d.repo = &info
+		}
+	}
+	if !opts.NoTree {
+		d.tree = generateFileTree(root, opts.FullTree)
+	}
+	for _, dir := range bundles {
+		b := promptBundle{path: show(dir.path), files: collectFiles(root, bundleFiles(root, dir.path), opts)}
+		for i := range b.files {
+			rel, _ := filepath.Rel(dir.path, b.files[i].path)
+			b.files[i].path = filepath.ToSlash(rel)
+		}
+		d.bundles = append(d.bundles, b)
+	}
+	for _, c := range opts.Commands {
+		res, ok := opts.ran[c]
+		if !ok {
+			res = runCommand(root.path, c)
+		}
+		d.commands = append(d.commands, res)
+	}
+	if opts.Diff {
+		res := &diffResult{base: opts.diffBase(), staged: opts.Staged}
+		res.diff, res.err = gitDiff(root.path, res.base, res.staged)
+		if res.diff != "" || res.err != nil {
+			d.diff = res
+		}
+	}
+	if opts.Commits > 0 {
+		// a repository without commits has no log to show
+		d.commits, _ = gitLog(root.path, opts.Commits, opts.CommitFormat)
+	}
+	return d
+}
+
+// guidanceNames are root-level files of instructions for coding agents.
+var guidanceNames = []string{"AGENTS.md", "CLAUDE.md"}
+
+// guidanceFiles returns the guidance files present at root.
+func guidanceFiles(root string) []string {
+	var files []string
+	for _, name := range guidanceNames {
+		p := filepath.Join(root, name)
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// pathFormatter returns how emitted paths are written for base: absolute,
+// or slash-separated relative to the launch directory ("launch"), the root
+// ("root") or the top of the root's git repository ("repo"). Paths fall
+// back to absolute when the base can't be found.
+func pathFormatter(root, base string) func(string) string {
+	dir := pathBaseDir(root, base)
+	if dir == "" {
+		return func(p string) string { return p }
+	}
+	return func(p string) string {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return p
+		}
+		return filepath.ToSlash(rel)
+	}
+}
+
+// pathBaseDir is the directory --path-base writes paths relative to, or
+// "" when they are absolute.
+func pathBaseDir(root, base string) string {
+	dir := ""
+	switch base {
+	case "launch":
+		dir, _ = os.Getwd()
+	case "root":
+		dir = root
+	case "repo":
+		dir, _ = gitTopLevel(root)
+	}
+	return dir
+}
+
+// promptFile is a file as it will be emitted.
+type promptFile struct {
+	path    string
+	mode    string
+	notes   []string
+	content string
+	// text is false when content is a placeholder such as [Binary file].
+	text bool
+	// size is the file's length on disk; truncated is set when content
+	// holds only part of it.
+	size      int64
+	truncated bool
+}
+
+// collectFiles reads the files to emit, applying line ranges and content
+// options.
+func collectFiles(root *node, files []string, opts options) []promptFile {
+	ranges := selectedRanges(root)
+	pfs := make([]promptFile, 0, len(files))
+	for _, p := range files {
+		f := promptFile{path: p}
+		if opts.FileMode {
+			if info, err := os.Stat(p); err == nil {
+				f.mode = info.Mode().String()
+			}
+		}
+		f.read(ranges[p], opts)
+		if !f.text && f.content == "" {
+			continue
+		}
+		pfs = append(pfs, f)
+	}
+	return pfs
+}
+
+// dedupeFiles drops files whose text matches an earlier file once
+// whitespace is normalized, noting the collapsed paths on the copy that is
+// kept.
+func dedupeFiles(pfs []promptFile, show func(string) string) []promptFile {
+	canonical := map[string]int{}
+	dupes := map[int][]string{}
+	var kept []promptFile
+	for _, f := range pfs {
+		key := strings.Join(strings.Fields(f.content), " ")
+		if !f.text || key == "" {
+			kept = append(kept, f)
+			continue
+		}
+		if i, ok := canonical[key]; ok {
+			dupes[i] = append(dupes[i], show(f.path))
+			continue
+		}
+		canonical[key] = len(kept)
+		kept = append(kept, f)
+	}
+	for i, paths := range dupes {
+		kept[i].notes = append(kept[i].notes, "Also stands for these files, identical apart from whitespace: "+strings.Join(paths, ", "))
+	}
+	return kept
+}
+
+// orderFiles moves files matching any of the first globs to the front, in
+// glob order, keeping the remaining files in tree order. Globs match either
+// the base name or the slash-separated path relative to base.
+func orderFiles(base string, files []string, first []string) []string {
+	if len(first) == 0 {
+		return files
+	}
+	rank := func(p string) int {
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			rel = p
+		}
+		rel = filepath.ToSlash(rel)
+		for i, g := range first {
+			if ok, _ := filepath.Match(g, filepath.Base(p)); ok {
+				return i
+			}
+			if ok, _ := filepath.Match(g, rel); ok {
+				return i
+			}
+		}
+		return len(first)
+	}
+	ordered := slices.Clone(files)
+	slices.SortStableFunc(ordered, func(a, b string) int {
+		return rank(a) - rank(b)
+	})
+	return ordered
+}
+
+// read fills in the text to emit for f, applying its line range and any
+// content transforms, along with notes describing them. text is left false
+// when the file couldn't be read as text; content is also empty when the
+// file should be left out.
+func (f *promptFile) read(r lineRange, opts options) {
+	b, err := os.ReadFile(f.path)
+	f.size = int64(len(b))
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		f.content = "[Permission denied]"
+		return
+	case err != nil:
+		f.content = "[Unreadable file: " + err.Error() + "]"
+		return
+	case strings.Contains(string(b), "\x00"):
+		f.content, f.notes = binaryContent(b, opts)
+		// only base64 and hexdump show part of the file, noting how much
+		f.truncated = len(f.notes) > 0 && len(b) > opts.BinaryBytes
+		return
+	}
+	f.text = true
+	if r.start > 0 {
+		f.content = sliceLines(string(b), r)
+		f.notes = []string{fmt.Sprintf("Only lines %d-%d are included.", r.start, r.end)}
+		f.truncated = true
+		return
+	}
+	f.content = string(b)
+	if opts.NotebookCells && strings.EqualFold(filepath.Ext(f.path), ".ipynb") {
+		if cells, ok := notebookCells(b); ok {
+			f.content = cells
+			f.notes = append(f.notes, "Notebook reduced to its code and markdown cells.")
+		}
+	}
+	if limit := opts.maxSizeFor(f.path); limit > 0 && int64(len(f.content)) > limit {
+		size := len(f.content)
+		f.content = truncateText(f.content, int(limit))
+		f.notes = append(f.notes, fmt.Sprintf("Truncated to the first %d of %d bytes.", len(f.content), size))
+		f.truncated = true
+	}
+}
+
+// truncateSnap is how far before the limit truncateText looks for a line
+// break to cut at.
+const truncateSnap = 4 << 10
+
+// truncateText cuts s to at most n bytes, at a line break in the last
+// truncateSnap bytes if there is one and otherwise between runes.
+func truncateText(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	s = s[:n]
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 && n-i <= truncateSnap {
+		return s[:i]
+	}
+	return s
+}
+
+// binaryContent represents binary data b according to --binary-mode: a
+// placeholder, nothing, or the first --binary-bytes as base64 or a hexdump.
+func binaryContent(b []byte, opts options) (string, []string) {
+	head := b[:min(len(b), max(opts.BinaryBytes, 0))]
+	note := fmt.Sprintf("Binary file of %d bytes; the first %d are shown as %s.", len(b), len(head), opts.BinaryMode)
+	switch opts.BinaryMode {
+	case "omit":
+		return "", nil
+	case "base64":
+		enc := base64.StdEncoding.EncodeToString(head)
+		var lines []string
+		for len(enc) > 76 {
+			lines = append(lines, enc[:76])
+			enc = enc[76:]
+		}
+		return strings.Join(append(lines, enc), "\n"), []string{note}
+	case "hexdump":
+		return strings.TrimSuffix(hex.Dump(head), "\n"), []string{note}
+	}
+	return "[Binary file]", nil
+}
+
+// extensionSummary counts files by extension, most common first, e.g.
+// "5 .go, 2 .md, 1 Makefile". Files without an extension count by name.
+func extensionSummary(files []string) string {
+	counts := map[string]int{}
+	for _, p := range files {
+		ext := strings.ToLower(filepath.Ext(p))
+		if ext == "" {
+			ext = filepath.Base(p)
+		}
+		counts[ext]++
+	}
+	exts := make([]string, 0, len(counts))
+	for ext := range counts {
+		exts = append(exts, ext)
+	}
+	slices.SortFunc(exts, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	parts := make([]string, len(exts))
+	for i, ext := range exts {
+		parts[i] = strconv.Itoa(counts[ext]) + " " + ext
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkReadable returns an error naming the first file that can't be read.
+func checkReadable(files []string) error {
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// sliceLines returns the lines of s covered by r.
+func sliceLines(s string, r lineRange) string {
+	lines := strings.Split(s, "\n")
+	start := min(r.start, len(lines)) - 1
+	end := min(r.end, len(lines))
+	return strings.Join(lines[start:end], "\n")
+}
+
+// selectedRanges returns the line ranges recorded on selected files.
+func selectedRanges(root *node) map[string]lineRange {
+	ranges := map[string]lineRange{}
+	var collect func(n *node)
+	collect = func(n *node) {
+		if n.selected && !n.isDir && n.lines.start > 0 {
+			ranges[n.path] = n.lines
+		}
+		for _, c := range n.children {
+			collect(c)
+		}
+	}
+	collect(root)
+	return ranges
+}
+
+func selectedFiles(root *node) []string {
+	files := []string{}
+	for _, n := range selectedNodes(root) {
+		files = append(files, n.path)
+	}
+	return files
+}
+
+// selectedNodes returns the selected files under root in tree order.
+func selectedNodes(root *node) []*node {
+	var nodes []*node
+	var collect func(n *node)
+	collect = func(n *node) {
+		if n.selected && !n.isDir {
+			nodes = append(nodes, n)
+		}
+		if n.childrenLoaded {
+			for _, c := range n.children {
+				collect(c)
+			}
+		}
+	}
+	collect(root)
+	return nodes
+}
+
+// generateFileTree draws the nodes under root that contain a selection or
+// a structure-marked directory, plus everything loaded beneath marked
+// directories; full draws every loaded node.
+func generateFileTree(root *node, full bool) string {
+	include := func(c *node) bool { return c.selected || hasSelected(c) || underMarked(c) }
+	if full {
+		include = func(*node) bool { return true }
+	}
+	var sb strings.Builder
+	children := []*node{}
+	for _, c := range root.children {
+		if include(c) {
+			children = append(children, c)
+		}
+	}
+	for i, c := range children {
+		isLast := i == len(children)-1
+		sb.WriteString(generateTreeRec(c, "", isLast, include))
+	}
+	return sb.String()
+}
+
+func generateTreeRec(n *node, prefix string, isLast bool, include func(*node) bool) string {
+	var s string
+	name := filepath.Base(n.path)
+	if isLast {
+		s = prefix + "└── " + name + "\n"
+		prefix += "    "
+	} else {
+		s = prefix + "├── " + name + "\n"
+		prefix += "│   "
+	}
+	children := []*node{}
+	for _, c := range n.children {
+		if include(c) {
+			children = append(children, c)
+		}
+	}
+	for i, c := range children {
+		isLastChild := i == len(children)-1
+		s += generateTreeRec(c, prefix, isLastChild, include)
+	}
+	return s
+}
+
+// markedDirs returns the outermost directories marked for structure, in
+// tree order.
+func markedDirs(root *node) []*node {
+	var dirs []*node
+	var collect func(n *node)
+	collect = func(n *node) {
+		if n.marked {
+			dirs = append(dirs, n)
+			return
+		}
+		for _, c := range n.children {
+			collect(c)
+		}
+	}
+	collect(root)
+	return dirs
+}
+
+// bundleFiles lists every file under dir in the order the tree shows them,
+// skipping .git and ignored paths.
+func bundleFiles(root *node, dir string) []string {
+	var files []string
+	walkFiles(root, dir, func(p string) error {
+		files = append(files, p)
+		return nil
+	})
+	return files
+}
+
+// isWithin reports whether p is dir or lies beneath it.
+func isWithin(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// underMarked reports whether n or one of its ancestors is marked for
+// structure, or n contains a marked directory.
+func underMarked(n *node) bool {
+	for p := n; p != nil; p = p.parent {
+		if p.marked {
+			return true
+		}
+	}
+	return hasMarked(n)
+}
+
+func hasMarked(n *node) bool {
+	if n.marked {
+		return true
+	}
+	for _, c := range n.children {
+		if hasMarked(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSelected(n *node) bool {
+	if n.selected && !n.isDir {
+		return true
+	}
+	if n.childrenLoaded {
+		for _, c := range n.children {
+			if hasSelected(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestBinaryContent(t *testing.T) {
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	tests := []struct {
+		name      string
+		mode      string
+		bytes     int
+		size      int
+		want      string
+		truncated bool
+	}{
+		{"placeholder", "placeholder", 64, 200, "[Binary file]", false},
+		{"omit", "omit", 64, 200, "", false},
+		{"base64 capped", "base64", 64, 200, base64.StdEncoding.EncodeToString(data[:64]), true},
+		{"base64 whole", "base64", 64, 30, base64.StdEncoding.EncodeToString(data[:30]), false},
+		{"base64 wrapped", "base64", 150, 150, base64.StdEncoding.EncodeToString(data[:150]), false},
+		{"hexdump capped", "hexdump", 16, 200, strings.TrimSuffix(hex.Dump(data[:16]), "\n"), true},
+		{"hexdump whole", "hexdump", 64, 40, strings.TrimSuffix(hex.Dump(data[:40]), "\n"), false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".bin")
+			if err := os.WriteFile(path, data[:tt.size], 0o644); err != nil {
+				t.Fatal(err)
+			}
+			f := promptFile{path: path}
+			f.read(lineRange{}, options{BinaryMode: tt.mode, BinaryBytes: tt.bytes})
+			if f.text {
+				t.Error("binary file read as text")
+			}
+			got := f.content
+			if tt.mode == "base64" {
+				lines := strings.Split(got, "\n")
+				for i, line := range lines {
+					if len(line) > 76 || i < len(lines)-1 && len(line) != 76 {
+						t.Errorf("line %d is %d columns, want 76 except the last", i+1, len(line))
+					}
+				}
+				got = strings.Join(lines, "")
+			}
+			if got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+			if f.truncated != tt.truncated {
+				t.Errorf("truncated = %v, want %v", f.truncated, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	minified := "// header\n" + strings.Repeat("x", 100_000)
+	nearBreak := strings.Repeat("a", 9_000) + "\n" + strings.Repeat("b", 5_000)
+	wide := strings.Repeat("é", 10)
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want int
+	}{
+		{"short", "abc", 10, 3},
+		{"far from a break", minified, 50_000, 50_000},
+		{"near a break", nearBreak, 10_000, 9_000},
+		{"mid rune", wide, 5, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateText(tt.s, tt.n)
+			if len(got) != tt.want || !strings.HasPrefix(tt.s, got) || !utf8.ValidString(got) {
+				t.Errorf("kept %d bytes (valid UTF-8 %v), want a %d byte prefix", len(got), utf8.ValidString(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncatedNote(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "min.js")
+	if err := os.WriteFile(path, []byte("a\n"+strings.Repeat("x", 20_000)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := promptFile{path: path}
+	f.read(lineRange{}, options{MaxFileSize: 10_000})
+	if len(f.content) != 10_000 || !f.truncated {
+		t.Fatalf("kept %d bytes, truncated %v; want 10000 and true", len(f.content), f.truncated)
+	}
+	if want := "Truncated to the first 10000 of 20002 bytes."; len(f.notes) != 1 || f.notes[0] != want {
+		t.Errorf("notes = %q, want %q", f.notes, want)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// recipePrefix tags a recipe string and its format version. A new encoding
+// gets a new prefix so older builds reject it instead of misreading it.
+const recipePrefix = "ctx1."
+
+// maxRecipeSize bounds the decoded JSON of a pasted recipe.
+const maxRecipeSize = 1 << 20
+
+// encodeRecipe packs rec into a single pasteable line: the prefix followed
+// by gzipped JSON in unpadded URL-safe base64. The root is dropped since
+// it is machine specific; paths are already relative to it.
+func encodeRecipe(rec selectionRecord) (string, error) {
+	rec.Root = ""
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return recipePrefix + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeRecipe(s string) (selectionRecord, error) {
+	var rec selectionRecord
+	s = strings.TrimSpace(s)
+	data, ok := strings.CutPrefix(s, recipePrefix)
+	if !ok {
+		return rec, fmt.Errorf("recipe: unsupported format (want %s...)", recipePrefix)
+	}
+	z, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return rec, fmt.Errorf("recipe: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return rec, fmt.Errorf("recipe: %w", err)
+	}
+	b, err := io.ReadAll(io.LimitReader(zr, maxRecipeSize+1))
+	if err != nil {
+		return rec, fmt.Errorf("recipe: %w", err)
+	}
+	if len(b) > maxRecipeSize {
+		return rec, fmt.Errorf("recipe: larger than %d bytes", maxRecipeSize)
+	}
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return rec, fmt.Errorf("recipe: %w", err)
+	}
+	if rec.Version < 1 || rec.Version > recordVersion {
+		return rec, fmt.Errorf("recipe: unsupported record version %d", rec.Version)
+	}
+	// a recipe comes from someone else: it must not run commands, read
+	// local files or send the API key elsewhere
+	rec.Options.Commands = nil
+	rec.Options.APIBase = ""
+	rec.Options.APIKeyEnv = ""
+	rec.Options.Template = ""
+	rec.Options.SystemFile = ""
+	return rec, nil
+}
+
+// recipeOptions are the config keys a recipe may set: what is selected
+// and how the prompt is formatted.
+var recipeOptions = map[string]bool{
+	"full_tree": true, "no_tree": true, "notebook_cells": true, "summary": true,
+	"file_mode": true, "file_tokens": true, "dedupe": true, "scope_note": true,
+	"bundle_marked": true, "fence": true, "guidance": true, "diff": true,
+	"repo_info": true, "no_default_excludes": true, "no_gitignore": true,
+	"format": true, "system": true, "path_base": true, "max_file_size": true,
+	"max_file_sizes": true, "chunk_size": true, "model": true, "budget": true,
+	"strict_budget": true, "trim_strategy": true, "binary_mode": true,
+	"binary_bytes": true, "diff_ref": true, "changed_since": true, "staged": true,
+	"recent_commits": true, "commit_format": true, "first": true, "select": true,
+	"include": true, "exclude": true,
+}
+
+// applyRecipeOptions overlays the options saved in a recipe onto opts.
+// Only the recipeOptions are affected.
+func applyRecipeOptions(s string, opts *options) error {
+	rec, err := decodeRecipe(s)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(rec.Options)
+	if err != nil {
+		return err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for key := range fields {
+		if !recipeOptions[key] {
+			delete(fields, key)
+		}
+	}
+	if b, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, opts)
+}
+
+// loadRecipe pre-selects the files listed in a recipe string.
+func (m *model) loadRecipe(s string) {
+	rec, err := decodeRecipe(s)
+	if err != nil {
+		m.warning = err.Error()
+		return
+	}
+	if missing := m.applyRecord(rec); len(missing) > 0 {
+		m.warning = fmt.Sprintf("recipe: %d missing: %s", len(missing), strings.Join(missing, ", "))
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const recordVersion = 1
+
+// selectionRecord describes how a prompt was assembled: the root, the
+// selected paths relative to it, and the options in effect.
+type selectionRecord struct {
+	Version int      `json:"version"`
+	Root    string   `json:"root"`
+	Paths   []string `json:"paths"`
+	// Ranges maps a path to the "start-end" lines included for it.
+	Ranges map[string]string `json:"ranges,omitempty"`
+	// Structure lists directories included in the tree without their files.
+	Structure []string `json:"structure,omitempty"`
+	Options   options  `json:"options"`
+}
+
+func newRecord(root *node, opts options) selectionRecord {
+	rec := selectionRecord{
+		Version: recordVersion,
+		Root:    root.path,
+		Paths:   []string{},
+		Options: opts,
+	}
+	ranges := selectedRanges(root)
+	for _, p := range selectedFiles(root) {
+		rel, err := filepath.Rel(root.path, p)
+		if err != nil {
+			rel = p
+		}
+		rel = filepath.ToSlash(rel)
+		rec.Paths = append(rec.Paths, rel)
+		if r, ok := ranges[p]; ok {
+			if rec.Ranges == nil {
+				rec.Ranges = map[string]string{}
+			}
+			rec.Ranges[rel] = fmt.Sprintf("%d-%d", r.start, r.end)
+		}
+	}
+	var marked func(n *node)
+	marked = func(n *node) {
+		if n.marked {
+			if rel, err := filepath.Rel(root.path, n.path); err == nil {
+				rec.Structure = append(rec.Structure, filepath.ToSlash(rel))
+			}
+		}
+		for _, c := range n.children {
+			marked(c)
+		}
+	}
+	marked(root)
+	return rec
+}
+
+func writeRecord(path string, rec selectionRecord) error {
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+func readRecord(path string) (selectionRecord, error) {
+	var rec selectionRecord
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return rec, fmt.Errorf("%s: %w", path, err)
+	}
+	if rec.Version > recordVersion {
+		return rec, fmt.Errorf("%s: unsupported record version %d", path, rec.Version)
+	}
+	return rec, nil
+}
+
+// loadManifest pre-selects the files listed in a selection record,
+// resolving them against the current root and reporting any that are gone.
+func (m *model) loadManifest(path string) {
+	rec, err := readRecord(path)
+	if err != nil {
+		m.warning = "manifest: " + err.Error()
+		return
+	}
+	if missing := m.applyRecord(rec); len(missing) > 0 {
+		m.warning = fmt.Sprintf("manifest: %d missing: %s", len(missing), strings.Join(missing, ", "))
+	}
+}
+
+// applyRecord selects rec's paths under the current root and returns the
+// ones that no longer exist.
+func (m *model) applyRecord(rec selectionRecord) []string {
+	var missing []string
+	for _, p := range rec.Paths {
+		n := revealPath(m.root, filepath.Join(m.root.path, filepath.FromSlash(p)), m.watcher)
+		if n == nil || n.isDir {
+			missing = append(missing, p)
+			continue
+		}
+		n.selected = true
+		if spec, ok := rec.Ranges[p]; ok {
+			var r lineRange
+			if _, err := fmt.Sscanf(spec, "%d-%d", &r.start, &r.end); err == nil && r.start > 0 && r.end >= r.start {
+				n.lines = r
+			}
+		}
+	}
+	for _, p := range rec.Structure {
+		n := revealPath(m.root, filepath.Join(m.root.path, filepath.FromSlash(p)), m.watcher)
+		if n == nil || !n.isDir {
+			missing = append(missing, p)
+			continue
+		}
+		n.marked = true
+	}
+	m.reflatten()
+	return missing
+}
+
+// projectStatePath returns where per-project state of the given kind is
+// kept for root: the user config dir, keyed by a hash of the absolute root
+// path.
+func projectStatePath(kind, root string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(dir, "ctx-tui", kind, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// savedSelectionPath returns where the last selection for root is kept.
+func savedSelectionPath(root string) (string, error) {
+	return projectStatePath("selections", root)
+}
+
+func saveSelection(root *node, opts options) error {
+	path, err := savedSelectionPath(root.path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeRecord(path, newRecord(root, opts))
+}
+
+// loadSavedSelection returns the last selection saved for root, if any.
+func loadSavedSelection(root string) (selectionRecord, bool) {
+	path, err := savedSelectionPath(root)
+	if err != nil {
+		return selectionRecord{}, false
+	}
+	rec, err := readRecord(path)
+	if err != nil || rec.Root != root || len(rec.Paths) == 0 {
+		return selectionRecord{}, false
+	}
+	return rec, true
+}
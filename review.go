@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// reviewSection is the line where a file's block starts in the prompt
+// review.
+type reviewSection struct {
+	line int
+	node *node
+}
+
+// renderReview regenerates the prompt into the review pane and indexes
+// the file blocks in it so they can be stepped through and deselected.
+func (m *model) renderReview() string {
+	d := gatherPrompt(m.root, selectedFiles(m.root), m.textarea.Value(), m.promptOptions())
+	prompt := renderPrompt(d)
+	m.viewport.SetContent(prompt)
+	m.chunks = nil
+	m.root.markIncluded()
+
+	show := pathFormatter(m.root.path, m.opts.PathBase)
+	byPath := map[string]*node{}
+	for _, n := range selectedNodes(m.root) {
+		byPath[show(n.path)] = n
+	}
+	m.reviewSections = nil
+	for i, line := range fileStarts(d, prompt) {
+		if n, ok := byPath[d.files[i].path]; ok && line >= 0 {
+			m.reviewSections = append(m.reviewSections, reviewSection{line: line, node: n})
+		}
+	}
+	m.reviewCursor = min(m.reviewCursor, max(len(m.reviewSections)-1, 0))
+	return prompt
+}
+
+// fileStarts finds the line each of d.files starts on in prompt, which
+// was rendered from d, or -1 where a file's block can't be found.
+func fileStarts(d promptData, prompt string) []int {
+	header := fileHeaders[d.opts.Format]
+	if d.opts.tmpl != nil || header.match == nil {
+		header = templateHeader(d.tree)
+	}
+	lines := strings.Split(prompt, "\n")
+	starts := make([]int, len(d.files))
+	next, offset := 0, 0
+	// files are emitted in order, so each header is looked for after the
+	// previous file's content, where its path may also appear
+	for j, f := range d.files {
+		starts[j] = -1
+		for i, pos := next, offset; i < len(lines); i, pos = i+1, pos+len(lines[i])+1 {
+			if !header.match(lines[i], f.path) {
+				continue
+			}
+			starts[j] = max(i-header.above, 0)
+			next, offset = i+1, min(pos+len(lines[i])+1, len(prompt))
+			if c := strings.TrimSuffix(f.content, "\n"); c != "" {
+				if k := strings.Index(prompt[offset:], c); k >= 0 {
+					// resume on the line after the content ends
+					end := offset + k + len(c)
+					last := next + strings.Count(prompt[offset:end], "\n")
+					next, offset = last+1, min(strings.LastIndexByte(prompt[:end], '\n')+1+len(lines[last])+1, len(prompt))
+				}
+			}
+			break
+		}
+	}
+	return starts
+}
+
+// fileHeader recognizes the line naming a file at the top of its block;
+// the block starts above lines before it.
+type fileHeader struct {
+	match func(line, path string) bool
+	above int
+}
+
+// fileHeaders are the file headers of each --format.
+var fileHeaders = map[string]fileHeader{
+	"xml":      {func(line, path string) bool { return line == "<file_path>"+path+"</file_path>" }, 1},
+	"markdown": {func(line, path string) bool { return line == "### "+path }, 0},
+	"repomix":  {func(line, path string) bool { return line == "File: "+path }, 1},
+	"json": {func(line, path string) bool {
+		v, ok := strings.CutPrefix(strings.TrimSpace(line), `"path": `)
+		var p string
+		return ok && json.Unmarshal([]byte(strings.TrimSuffix(v, ",")), &p) == nil && p == path
+	}, 1},
+}
+
+// templateHeader takes the first line mentioning a file's path as its
+// header, since a --template can lay files out any way it likes. Lines of
+// the file tree are skipped.
+func templateHeader(tree string) fileHeader {
+	treeLines := map[string]bool{}
+	for _, l := range strings.Split(tree, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			treeLines[l] = true
+		}
+	}
+	return fileHeader{match: func(line, path string) bool {
+		return strings.Contains(line, path) && !treeLines[strings.TrimSpace(line)]
+	}}
+}
+
+// jumpSection moves to the next or previous file block and scrolls the
+// review to it.
+func (m *model) jumpSection(delta int) {
+	if len(m.reviewSections) == 0 {
+		return
+	}
+	m.reviewCursor = min(max(m.reviewCursor+delta, 0), len(m.reviewSections)-1)
+	m.viewport.SetYOffset(m.reviewSections[m.reviewCursor].line)
+}
+
+// deselectSection drops the current file block's file from the selection
+// and re-renders, moving to the block that takes its place.
+func (m *model) deselectSection() tea.Cmd {
+	if m.reviewCursor >= len(m.reviewSections) {
+		return nil
+	}
+	n := m.reviewSections[m.reviewCursor].node
+	n.selected = false
+	m.renderReview()
+	m.reflatten()
+	m.jumpSection(0)
+	return m.flash("Deselected " + displayPath(m.root.path, m.opts.PathBase, n.path))
+}
+
+// reviewHeading labels the review with the current file block.
+func (m model) reviewHeading() string {
+	if m.reviewCursor >= len(m.reviewSections) {
+		return "Prompt Review:"
+	}
+	return fmt.Sprintf("Prompt Review: %d/%d %s", m.reviewCursor+1, len(m.reviewSections), displayPath(m.root.path, m.opts.PathBase, m.reviewSections[m.reviewCursor].node.path))
+}
+
+// displayPath writes p as emitted paths are written for root.
+func displayPath(root, base, p string) string {
+	return pathFormatter(root, base)(p)
+}
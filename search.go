@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+)
+
+// searchMaxFileSize bounds the files a content search will read.
+const searchMaxFileSize = 1 << 20
+
+type searchKind int
+
+const (
+	contentSearch searchKind = iota
+	nameSearch
+	// contentFilter matches contents like contentSearch but shows the
+	// matches in place in the tree.
+	contentFilter
+)
+
+// searchMatch is a file found by a search; count is the number of matching
+// lines for content searches.
+type searchMatch struct {
+	path  string
+	count int
+}
+
+// searchResultMsg delivers a batch of matches for search id; done is set
+// once the search has finished or been cancelled.
+type searchResultMsg struct {
+	id      int
+	matches []searchMatch
+	done    bool
+}
+
+// searchState is a global search over the whole root, independent of which
+// directories are loaded in the tree.
+type searchState struct {
+	active  bool
+	editing bool
+	kind    searchKind
+	input   textinput.Model
+	id      int
+	cancel  context.CancelFunc
+	running bool
+	results <-chan searchMatch
+	matches []searchMatch
+}
+
+// runSearch walks the files of root's tree and feeds every file to a pool of workers, which
+// stream back the files accepted by match. Cancelling ctx stops the walk
+// and the workers; the returned channel is closed when they are done.
+func runSearch(ctx context.Context, root *node, workers int, match func(path string) (int, bool)) <-chan searchMatch {
+	paths := make(chan string)
+	out := make(chan searchMatch)
+	go func() {
+		defer close(paths)
+		walkFiles(root, root.path, func(p string) error {
+			select {
+			case paths <- p:
+				return nil
+			case <-ctx.Done():
+				return filepath.SkipAll
+			}
+		})
+	}()
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				count, ok := match(p)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- searchMatch{path: p, count: count}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// waitSearch blocks for the next match and then drains whatever else is
+// ready, so results arrive in batches without holding up Update.
+func waitSearch(id int, ch <-chan searchMatch) tea.Cmd {
+	return func() tea.Msg {
+		first, ok := <-ch
+		if !ok {
+			return searchResultMsg{id: id, done: true}
+		}
+		msg := searchResultMsg{id: id, matches: []searchMatch{first}}
+		for len(msg.matches) < 256 {
+			select {
+			case sm, ok := <-ch:
+				if !ok {
+					msg.done = true
+					return msg
+				}
+				msg.matches = append(msg.matches, sm)
+			default:
+				return msg
+			}
+		}
+		return msg
+	}
+}
+
+// contentMatcher counts the lines of a file containing query, skipping
+// large and binary files. A query written /like this/ is a regular
+// expression; it is matched literally if it doesn't compile.
+func contentMatcher(query string, caseSensitive bool) func(string) (int, bool) {
+	contains := literalMatcher(query, caseSensitive)
+	if re, ok := queryRegexp(query, caseSensitive); ok {
+		contains = re.Match
+	}
+	return func(p string) (int, bool) {
+		info, err := os.Stat(p)
+		if err != nil || info.Size() > searchMaxFileSize {
+			return 0, false
+		}
+		b, err := os.ReadFile(p)
+		if err != nil || bytes.IndexByte(b, 0) >= 0 {
+			return 0, false
+		}
+		count := 0
+		for _, line := range bytes.Split(b, []byte("\n")) {
+			if contains(line) {
+				count++
+			}
+		}
+		return count, count > 0
+	}
+}
+
+func literalMatcher(query string, caseSensitive bool) func([]byte) bool {
+	if caseSensitive {
+		q := []byte(query)
+		return func(line []byte) bool { return bytes.Contains(line, q) }
+	}
+	q := []byte(strings.ToLower(query))
+	return func(line []byte) bool { return bytes.Contains(bytes.ToLower(line), q) }
+}
+
+// queryRegexp compiles a query written between slashes.
+func queryRegexp(query string, caseSensitive bool) (*regexp.Regexp, bool) {
+	if len(query) < 3 || query[0] != '/' || query[len(query)-1] != '/' {
+		return nil, false
+	}
+	expr := query[1 : len(query)-1]
+	if !caseSensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	return re, err == nil
+}
+
+// nameMatcher fuzzy-matches query against paths relative to root.
+func nameMatcher(root, query string, caseSensitive bool) func(string) (int, bool) {
+	return func(p string) (int, bool) {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return 0, false
+		}
+		filter := list.DefaultFilter
+		if caseSensitive {
+			filter = caseSensitiveFilter
+		}
+		return 0, len(filter(query, []string{rel})) > 0
+	}
+}
+
+// openSearch shows the search prompt for kind.
+func (m *model) openSearch(kind searchKind) tea.Cmd {
+	m.stopSearch()
+	ti := textinput.New()
+	switch kind {
+	case contentSearch:
+		ti.Prompt = "Search contents (text or /regexp/): "
+	case nameSearch:
+		ti.Prompt = "Find file: "
+	case contentFilter:
+		ti.Prompt = "Filter by contents (text or /regexp/): "
+	}
+	m.search = searchState{active: true, editing: true, kind: kind, input: ti, id: m.search.id}
+	m.applyFilterMode()
+	m.reflatten()
+	return m.search.input.Focus()
+}
+
+// closeSearch cancels any running search and returns to the tree.
+func (m *model) closeSearch() {
+	m.stopSearch()
+	m.search.active = false
+	m.search.editing = false
+	m.search.matches = nil
+	m.applyFilterMode()
+	m.reflatten()
+}
+
+func (m *model) stopSearch() {
+	if m.search.cancel != nil {
+		m.search.cancel()
+		m.search.cancel = nil
+	}
+	m.search.running = false
+}
+
+// restartSearch cancels the previous query and starts one for the current
+// input, discarding results from older searches by id.
+func (m *model) restartSearch() tea.Cmd {
+	m.stopSearch()
+	m.search.id++
+	m.search.matches = nil
+	m.reflatten()
+	query := m.search.input.Value()
+	if query == "" {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.search.cancel = cancel
+	m.search.running = true
+	match := contentMatcher(query, m.caseSensitive)
+	if m.search.kind == nameSearch {
+		match = nameMatcher(m.root.path, query, m.caseSensitive)
+	}
+	m.search.results = runSearch(ctx, m.root, m.opts.SearchWorkers, match)
+	return waitSearch(m.search.id, m.search.results)
+}
+
+// handleSearchResult merges a batch into the results view and keeps
+// listening until the search is done.
+func (m *model) handleSearchResult(msg searchResultMsg) tea.Cmd {
+	if msg.id != m.search.id || !m.search.active {
+		return nil
+	}
+	m.search.matches = append(m.search.matches, msg.matches...)
+	m.reflatten()
+	if msg.done {
+		m.search.running = false
+		m.search.cancel = nil
+		return nil
+	}
+	return waitSearch(m.search.id, m.search.results)
+}
+
+// searchItems lists search results, loading the tree nodes they refer to
+// without expanding their directories.
+func searchItems(root *node, matches []searchMatch, watcher *fsnotify.Watcher) []list.Item {
+	var items []list.Item
+	for _, sm := range matches {
+		n := lookupPath(root, sm.path, watcher, false)
+		if n == nil {
+			continue
+		}
+		label, err := filepath.Rel(root.path, sm.path)
+		if err != nil {
+			label = sm.path
+		}
+		if sm.count > 0 {
+			label += " (" + strconv.Itoa(sm.count) + ")"
+		}
+		items = append(items, item{node: n, label: label})
+	}
+	return items
+}
+
+// searchTreeItems lists the matching files in tree form under the
+// directories that lead to them, which are shown open whether or not they
+// are expanded in the tree. Files are labelled with their match counts.
+func searchTreeItems(root *node, matches []searchMatch, watcher *fsnotify.Watcher) []list.Item {
+	counts := map[string]int{}
+	dirs := map[string]bool{}
+	for _, sm := range matches {
+		if lookupPath(root, sm.path, watcher, false) == nil {
+			continue
+		}
+		counts[sm.path] = sm.count
+		for d := filepath.Dir(sm.path); d != root.path && isWithin(root.path, d) && !dirs[d]; d = filepath.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	var items []list.Item
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		for _, c := range n.children {
+			if count, ok := counts[c.path]; ok {
+				items = append(items, item{node: c, depth: depth, label: filepath.Base(c.path) + " (" + strconv.Itoa(count) + ")"})
+			} else if dirs[c.path] {
+				items = append(items, item{node: c, depth: depth})
+				walk(c, depth+1)
+			}
+		}
+	}
+	walk(root, 0)
+	return items
+}
+
+// searchFooter describes the search prompt and its progress.
+func (m model) searchFooter() string {
+	status := strconv.Itoa(len(m.search.matches)) + " matches"
+	if m.search.kind != nameSearch {
+		lines := 0
+		for _, sm := range m.search.matches {
+			lines += sm.count
+		}
+		status = strconv.Itoa(len(m.search.matches)) + " files, " + strconv.Itoa(lines) + " lines"
+	}
+	if m.search.running {
+		status = "searching… " + status
+	}
+	hint := "enter: browse  esc: close"
+	if !m.search.editing {
+		hint = "ctrl+f/ctrl+p/ctrl+g: edit query  esc: close"
+	}
+	return m.search.input.View() + "  " + blurredStyle.Render(status+"  "+hint)
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const sendTimeout = 5 * time.Minute
+
+// defaultSystemPrompt tells the model how the prompt is laid out unless
+// --system gives a prompt of its own. Each API puts it where the model
+// expects instructions rather than in the user turn.
+const defaultSystemPrompt = "You are an expert software engineer. The user shares files from their project, followed by their request. Answer the request using those files, and give paths when referring to them."
+
+// sendModels are offered by the model picker.
+var sendModels = []string{
+	"gpt-4o", "gpt-4o-mini", "gpt-4.1", "o3", "o4-mini",
+	"claude-sonnet-4-5", "claude-opus-4-1", "claude-haiku-4-5",
+}
+
+// sendTarget picks the provider and model the prompt is sent to. The
+// model is --send-model, or --model unless it names only a tokenizer, or
+// the provider's default. Without --provider, Claude models go to
+// Anthropic and the rest to OpenAI.
+func (o options) sendTarget() (string, provider, string) {
+	model := o.SendModel
+	if _, ok := tokenizers[o.Model]; model == "" && !ok {
+		model = o.Model
+	}
+	name := o.Provider
+	if name == "" {
+		name = "openai"
+		if strings.HasPrefix(model, "claude") || model == "" && o.Model == "claude" {
+			name = "anthropic"
+		}
+	}
+	p := providers[name]
+	if model == "" {
+		model = p.model
+	}
+	if o.APIBase != "" {
+		p.base = o.APIBase
+	}
+	if o.APIKeyEnv != "" {
+		p.keyEnv = o.APIKeyEnv
+	}
+	return name, p, model
+}
+
+// response is the state of the response pane: the conversation so far
+// and the answer being streamed or last received.
+type response struct {
+	model string
+	// past holds the earlier exchanges of the conversation.
+	past []exchange
+	// request is what was typed for the current exchange and asked what
+	// was sent for it.
+	request string
+	asked   chatMessage
+	text    string
+	err     error
+	sending bool
+	seq     int
+	cancel  context.CancelFunc
+	stream  <-chan responseMsg
+}
+
+// exchange is one question and its answer in a conversation.
+type exchange struct {
+	model   string
+	request string
+	asked   chatMessage
+	answer  string
+}
+
+// messages is the conversation to send: the earlier exchanges and the
+// current question.
+func (r response) messages() []chatMessage {
+	var msgs []chatMessage
+	for _, e := range r.past {
+		msgs = append(msgs, e.asked, chatMessage{Role: "assistant", Content: e.answer})
+	}
+	return append(msgs, r.asked)
+}
+
+// responseMsg carries the text streamed since the last one; done marks the
+// end of the answer.
+type responseMsg struct {
+	seq  int
+	text string
+	done bool
+	err  error
+}
+
+// waitResponse delivers the next part of the answer, joining whatever has
+// already arrived so a fast stream isn't redrawn per token. A closed
+// stream ends the answer.
+func waitResponse(seq int, ch <-chan responseMsg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-ch
+		for ok && !msg.done {
+			select {
+			case next, more := <-ch:
+				if !more {
+					return responseMsg{seq: seq, text: msg.text, done: true}
+				}
+				next.text = msg.text + next.text
+				msg = next
+			default:
+				return msg
+			}
+		}
+		if !ok {
+			return responseMsg{seq: seq, done: true}
+		}
+		return msg
+	}
+}
+
+// sendKey reads the API key of the provider prompts are sent to. It
+// warns and returns false if the key isn't set.
+func (m *model) sendKey() (string, provider, string, string, bool) {
+	name, p, model := m.opts.sendTarget()
+	var key string
+	if p.keyEnv != "" {
+		if key = os.Getenv(p.keyEnv); key == "" {
+			m.warning = "Set $" + p.keyEnv + " to send the prompt"
+			return "", p, "", "", false
+		}
+	}
+	return name, p, model, key, true
+}
+
+// sendPrompt builds the prompt for the selected files and request in the
+// format the provider reads best, or warns and returns false if it is
+// over budget.
+func (m *model) sendPrompt(provider, request string) (string, bool) {
+	if m.commandsPending() {
+		m.warning = "Waiting for the commands to finish"
+		return "", false
+	}
+	opts := m.promptOptions()
+	// the system prompt is sent as the system message instead
+	opts.system = ""
+	if provider == "anthropic" && opts.tmpl == nil {
+		// Claude is trained to read XML-tagged documents
+		opts.Format = "xml"
+	}
+	prompt := buildPrompt(m.root, selectedFiles(m.root), request, opts)
+	if m.warning = m.budgetError(prompt); m.warning != "" {
+		return "", false
+	}
+	return prompt, true
+}
+
+// systemPrompt is the system message sent with prompts.
+func (o options) systemPrompt() string {
+	if o.system == "" {
+		return defaultSystemPrompt
+	}
+	return o.system
+}
+
+// send streams the answer to the prompt from the API in the background
+// into the response pane, starting a new conversation.
+func (m *model) send() tea.Cmd {
+	name, p, modelName, key, ok := m.sendKey()
+	if !ok {
+		return nil
+	}
+	if m.opts.RequireRequest && strings.TrimSpace(m.textarea.Value()) == "" {
+		m.warning = "A request is required. Describe the task before sending."
+		return nil
+	}
+	prompt, ok := m.sendPrompt(name, m.textarea.Value())
+	if !ok {
+		return nil
+	}
+	request := m.textarea.Value()
+	return m.confirmCost([]chatMessage{{Role: "user", Content: prompt}}, func(m *model) tea.Cmd {
+		m.cancelSend()
+		m.response = response{seq: m.response.seq}
+		return m.converse(p, key, modelName, request, prompt)
+	})
+}
+
+// sendFollowUp continues the conversation with request, along with the
+// selected files as they are now if refresh is set.
+func (m *model) sendFollowUp(request string, refresh bool) tea.Cmd {
+	name, p, modelName, key, ok := m.sendKey()
+	if !ok {
+		return nil
+	}
+	content := request
+	if refresh {
+		if content, ok = m.sendPrompt(name, request); !ok {
+			return nil
+		}
+	}
+	next := m.response
+	// a failed exchange is dropped so the conversation still alternates
+	if next.err == nil && next.text != "" {
+		next.past = append(slices.Clone(next.past), exchange{model: next.model, request: next.request, asked: next.asked, answer: next.text})
+	}
+	next.asked = chatMessage{Role: "user", Content: content}
+	return m.confirmCost(next.messages(), func(m *model) tea.Cmd {
+		if m.followUp != nil {
+			m.endFollowUp()
+		}
+		m.cancelSend()
+		m.response.past = next.past
+		return m.converse(p, key, modelName, request, content)
+	})
+}
+
+// converse sends the conversation with content as the next question and
+// streams the answer into the response pane.
+func (m *model) converse(p provider, key, model, request, content string) tea.Cmd {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	ch := make(chan responseMsg, 64)
+	seq := m.response.seq + 1
+	r := &m.response
+	r.model, r.request, r.asked = model, request, chatMessage{Role: "user", Content: content}
+	r.text, r.err, r.sending, r.seq, r.cancel, r.stream = "", nil, true, seq, cancel, ch
+	messages := r.messages()
+	system := m.opts.systemPrompt()
+	m.focus = responseView
+	m.renderResponse()
+	m.viewport.GotoBottom()
+	go func() {
+		defer close(ch)
+		defer cancel()
+		deliver := func(msg responseMsg) {
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+			}
+		}
+		err := p.send(ctx, p.base, key, model, system, messages, func(text string) {
+			if text != "" {
+				deliver(responseMsg{seq: seq, text: text})
+			}
+		})
+		deliver(responseMsg{seq: seq, done: true, err: err})
+	}()
+	return waitResponse(seq, ch)
+}
+
+// cancelSend abandons a request still in flight, keeping what has
+// arrived.
+func (m *model) cancelSend() {
+	if m.response.sending {
+		m.response.cancel()
+		m.response.sending = false
+		m.response.err = errors.New("cancelled")
+	}
+}
+
+// handleResponse adds streamed text to the answer and keeps reading
+// until it ends.
+func (m *model) handleResponse(msg responseMsg) tea.Cmd {
+	if msg.seq != m.response.seq || !m.response.sending {
+		return nil
+	}
+	m.response.text += msg.text
+	if msg.done {
+		m.response.sending = false
+		m.response.err = msg.err
+		m.keepTranscript()
+	}
+	if m.focus == responseView {
+		// follow the answer unless scrolled up to read it
+		follow := m.viewport.AtBottom()
+		m.renderResponse()
+		if follow {
+			m.viewport.GotoBottom()
+		}
+	}
+	if msg.done {
+		return nil
+	}
+	return waitResponse(msg.seq, m.response.stream)
+}
+
+// keepTranscript saves a finished answer with its question under the
+// transcripts directory unless --no-transcripts is set.
+func (m *model) keepTranscript() {
+	r := m.response
+	if m.opts.NoTranscripts || r.err != nil || r.text == "" {
+		return
+	}
+	e := exchange{model: r.model, request: r.request, asked: r.asked, answer: r.text}
+	if _, err := saveTranscript(m.root.path, e, time.Now()); err != nil {
+		m.warning = "transcript: " + err.Error()
+	}
+}
+
+// renderResponse shows the answer so far, wrapped to the pane, and how
+// the request ended if it failed. In a conversation each answer follows
+// its question.
+func (m *model) renderResponse() {
+	var sb strings.Builder
+	if r := m.response; len(r.past) > 0 {
+		for _, e := range r.past {
+			sb.WriteString(focusedStyle.Render("› "+e.request) + "\n\n" + e.answer + "\n\n")
+		}
+		sb.WriteString(focusedStyle.Render("› "+r.request) + "\n\n")
+	}
+	text := m.response.text
+	switch {
+	case m.response.sending && text == "":
+		text = blurredStyle.Render("Waiting for " + m.response.model + "…")
+	case m.response.err != nil:
+		if text != "" {
+			text += "\n\n"
+		}
+		text += warningStyle.Render(m.response.err.Error())
+	}
+	sb.WriteString(text)
+	m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(sb.String()))
+}
+
+// updateResponse handles a key in the response pane.
+func (m *model) updateResponse(key string) tea.Cmd {
+	switch key {
+	case "esc":
+		if m.response.sending {
+			m.cancelSend()
+			m.renderResponse()
+			return nil
+		}
+		fallthrough
+	case "tab":
+		m.focus = acceptView
+		m.renderReview()
+	case "c":
+		if m.response.text == "" {
+			return m.flash("No answer to copy yet")
+		}
+		if err := copyToClipboard(m.response.text, m.opts); err != nil {
+			m.warning = "copy: " + err.Error()
+			return nil
+		}
+		return m.flash("Answer copied")
+	case "w":
+		if m.response.text == "" {
+			return m.flash("No answer to save yet")
+		}
+		return m.ask("Save answer to: ", "response.md", nil, saveResponse)
+	case "f":
+		if m.response.sending {
+			return m.flash("Wait for the answer to finish")
+		}
+		return m.startFollowUp()
+	case "e":
+		return m.ask("Export conversation to: ", "conversation.md", nil, exportConversation)
+	case "a":
+		if m.response.sending {
+			return m.flash("Wait for the answer to finish")
+		}
+		return m.openPatches()
+	case "s":
+		return m.send()
+	case "m":
+		return m.openModelPicker()
+	case "up", "k":
+		m.viewport.LineUp(1)
+	case "down", "j":
+		m.viewport.LineDown(1)
+	case "pgup":
+		m.viewport.PageUp()
+	case "pgdown":
+		m.viewport.PageDown()
+	}
+	return nil
+}
+
+// saveResponse writes the answer so far to path, relative to the
+// directory ctx-tui was started in.
+func saveResponse(m *model, path string) tea.Cmd {
+	if err := os.WriteFile(path, []byte(m.response.text), 0o644); err != nil {
+		m.warning = "save: " + err.Error()
+		return nil
+	}
+	return m.flash("Answer saved to " + path)
+}
+
+// transcript writes the conversation as Markdown, each question in full
+// as it was sent.
+func (r response) transcript() string {
+	var sb strings.Builder
+	write := func(model string, asked chatMessage, answer string) {
+		fmt.Fprintf(&sb, "## You\n\n%s\n\n## %s\n\n%s\n\n", strings.TrimSpace(asked.Content), model, strings.TrimSpace(answer))
+	}
+	for _, e := range r.past {
+		write(e.model, e.asked, e.answer)
+	}
+	write(r.model, r.asked, r.text)
+	return sb.String()
+}
+
+// exportConversation writes the conversation's transcript to path,
+// relative to the directory ctx-tui was started in.
+func exportConversation(m *model, path string) tea.Cmd {
+	if err := os.WriteFile(path, []byte(m.response.transcript()), 0o644); err != nil {
+		m.warning = "export: " + err.Error()
+		return nil
+	}
+	return m.flash("Conversation exported to " + path)
+}
+
+// startFollowUp moves to the request pane to write a follow-up question,
+// keeping the request the prompt was built from aside.
+func (m *model) startFollowUp() tea.Cmd {
+	m.followUp = &followUp{request: m.textarea.Value(), refresh: m.opts.RefreshFiles}
+	m.textarea.Reset()
+	m.focus = textAreaView
+	return m.textarea.Focus()
+}
+
+// followUp is a follow-up question being written in the request pane.
+type followUp struct {
+	// request is the prompt's request, restored once the follow-up is
+	// sent or abandoned.
+	request string
+	// refresh sends the selected files again, as they are now.
+	refresh bool
+}
+
+// endFollowUp puts the prompt's request back in the request pane.
+func (m *model) endFollowUp() {
+	m.textarea.SetValue(m.followUp.request)
+	m.textarea.Blur()
+	m.followUp = nil
+}
+
+// updateFollowUp handles a key while a follow-up is written: tab sends it,
+// ctrl+o toggles sending refreshed files, and esc returns to the answer.
+func (m *model) updateFollowUp(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "tab":
+		text := m.textarea.Value()
+		if strings.TrimSpace(text) == "" {
+			return m.flash("Write a follow-up first")
+		}
+		// the follow-up stays open if it can't be sent, or until its cost
+		// is confirmed
+		return m.sendFollowUp(text, m.followUp.refresh)
+	case "ctrl+o":
+		m.followUp.refresh = !m.followUp.refresh
+		return nil
+	case "esc":
+		m.endFollowUp()
+		m.focus = responseView
+		m.renderResponse()
+		return nil
+	}
+	var cmd tea.Cmd
+	m.textarea, cmd = m.textarea.Update(msg)
+	return cmd
+}
+
+// responseHeading titles the response pane.
+func (m model) responseHeading() string {
+	if m.response.sending {
+		return "Streaming from " + m.response.model + "…"
+	}
+	return "Response from " + m.response.model + ":"
+}
+
+// openModelPicker asks for the model to send to, suggesting known ones.
+func (m *model) openModelPicker() tea.Cmd {
+	_, _, current := m.opts.sendTarget()
+	return m.ask("Send to model: ", current, sendModels, func(m *model, name string) tea.Cmd {
+		m.opts.SendModel = name
+		via, _, _ := m.opts.sendTarget()
+		return m.flash("Sending to " + name + " via " + via)
+	})
+}
+
+// question is a one-line prompt in the footer, such as the model picker.
+// answer is called with the trimmed value unless it is empty.
+type question struct {
+	editing bool
+	input   textinput.Model
+	answer  func(m *model, value string) tea.Cmd
+}
+
+// ask opens a question filled in with value; tab completes a suggestion.
+func (m *model) ask(prompt, value string, suggestions []string, answer func(*model, string) tea.Cmd) tea.Cmd {
+	ti := textinput.New()
+	ti.Prompt = prompt
+	ti.SetSuggestions(suggestions)
+	ti.ShowSuggestions = len(suggestions) > 0
+	ti.SetValue(value)
+	m.question = question{editing: true, input: ti, answer: answer}
+	return m.question.input.Focus()
+}
+
+// updateQuestion handles a key while a question is open: enter answers
+// it and esc dismisses it.
+func (m *model) updateQuestion(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.question.editing = false
+		return nil
+	case "enter":
+		m.question.editing = false
+		value := strings.TrimSpace(m.question.input.Value())
+		if value == "" {
+			return nil
+		}
+		return m.question.answer(m, value)
+	}
+	var cmd tea.Cmd
+	m.question.input, cmd = m.question.input.Update(msg)
+	return cmd
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a size in bytes, written as a plain number or with a K, M or
+// G suffix (powers of 1024) and an optional B, e.g. 512K or 256kb. It is
+// accepted as a flag and as a JSON number or string.
+type byteSize int64
+
+func parseByteSize(s string) (byteSize, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if len(s) > 1 && strings.HasSuffix(s, "B") {
+		s = s[:len(s)-1]
+	}
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return byteSize(n * mult), nil
+}
+
+func (b byteSize) String() string { return strconv.FormatInt(int64(b), 10) }
+
+func (b *byteSize) Set(s string) error {
+	v, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+func (b *byteSize) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		return b.Set(s)
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// extSizes maps a lowercase extension such as ".json" to a size limit. As
+// a flag it is repeatable: --max-size-for json=100K.
+type extSizes map[string]byteSize
+
+func (e *extSizes) String() string {
+	var parts []string
+	for ext, size := range *e {
+		parts = append(parts, ext+"="+size.String())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (e *extSizes) Set(v string) error {
+	ext, size, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want ext=size, got %q", v)
+	}
+	n, err := parseByteSize(size)
+	if err != nil {
+		return err
+	}
+	if *e == nil {
+		*e = extSizes{}
+	}
+	(*e)[normalizeExt(ext)] = n
+	return nil
+}
+
+func (e *extSizes) UnmarshalJSON(data []byte) error {
+	var raw map[string]byteSize
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if *e == nil {
+		*e = extSizes{}
+	}
+	for ext, n := range raw {
+		(*e)[normalizeExt(ext)] = n
+	}
+	return nil
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// maxSizeFor returns the size limit for the file at p: its extension's
+// override if there is one, otherwise the global --max-file-size. Zero
+// means no limit.
+func (o options) maxSizeFor(p string) int64 {
+	if n, ok := o.MaxFileSizes[strings.ToLower(filepath.Ext(p))]; ok {
+		return int64(n)
+	}
+	return int64(o.MaxFileSize)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// statusEntry is a transient footer message.
+type statusEntry struct {
+	id   int
+	text string
+}
+
+// statusExpiredMsg removes the status entry with the given id.
+type statusExpiredMsg int
+
+// flash queues text for the footer and schedules its removal after the
+// configured status duration. The most recent live entry is displayed.
+func (m *model) flash(text string) tea.Cmd {
+	m.statusSeq++
+	id := m.statusSeq
+	m.statuses = append(m.statuses, statusEntry{id: id, text: text})
+	return tea.Tick(m.opts.StatusDuration, func(time.Time) tea.Msg {
+		return statusExpiredMsg(id)
+	})
+}
+
+func (m *model) expireStatus(id int) {
+	for i, s := range m.statuses {
+		if s.id == id {
+			m.statuses = append(m.statuses[:i], m.statuses[i+1:]...)
+			return
+		}
+	}
+}
+
+// currentStatus returns the newest transient message, if any.
+func (m model) currentStatus() (string, bool) {
+	if len(m.statuses) == 0 {
+		return "", false
+	}
+	return m.statuses[len(m.statuses)-1].text, true
+}
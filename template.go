@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are available to --template files in addition to the
+// text/template builtins.
+var templateFuncs = template.FuncMap{
+	// codeBlock fences its text, tagged with a language, so that no fence
+	// inside it can close the block early.
+	"codeBlock": codeBlock,
+	"join":      strings.Join,
+	"trim":      strings.TrimSpace,
+}
+
+// loadTemplate parses the --template file at path.
+func loadTemplate(path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New(path).Funcs(templateFuncs).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	// catch misspelled fields now rather than on accept
+	sample := jsonPrompt{Files: []jsonFile{{Path: "a"}}, Directories: []jsonBundle{{Path: "d", Files: []jsonFile{{Path: "b"}}}}, Commands: []jsonCommand{{Command: "c"}}, Diff: &jsonDiff{Base: "HEAD"}, Repo: &jsonRepo{}}
+	sample.Guidance = sample.Files
+	if err := t.Execute(new(strings.Builder), sample); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// renderTemplate executes the --template with the prompt's parts; see
+// jsonPrompt for the fields available. Token counts are always filled in.
+func renderTemplate(d promptData) string {
+	var sb strings.Builder
+	if err := d.opts.tmpl.Execute(&sb, exportPrompt(d, true)); err != nil {
+		return fmt.Sprintf("%s\n[template error: %v]", sb.String(), err)
+	}
+	return sb.String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// theme is the set of styles the UI is drawn with.
+type theme struct {
+	focused lipgloss.Style
+	blurred lipgloss.Style
+	warning lipgloss.Style
+	cursor  lipgloss.Style
+	marked  lipgloss.Style
+	// title marks the heading of the focused pane; listTitle and
+	// listTitleBlurred style the tree's heading when it has focus or not.
+	title            lipgloss.Style
+	listTitle        lipgloss.Style
+	listTitleBlurred lipgloss.Style
+	// gitModified, gitStaged and gitUntracked color the git status badges
+	// in the tree.
+	gitModified  lipgloss.Style
+	gitStaged    lipgloss.Style
+	gitUntracked lipgloss.Style
+	// separator draws a rule between the tree and the right pane.
+	separator bool
+}
+
+var ui = defaultTheme()
+
+func defaultTheme() theme {
+	return theme{
+		focused:          lipgloss.NewStyle().Foreground(lipgloss.Color("205")),
+		blurred:          lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+		warning:          lipgloss.NewStyle().Foreground(lipgloss.Color("203")),
+		cursor:           lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("170")),
+		marked:           lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+		title:            lipgloss.NewStyle(),
+		listTitle:        list.DefaultStyles().Title,
+		listTitleBlurred: list.DefaultStyles().Title,
+		gitModified:      lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+		gitStaged:        lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+		gitUntracked:     lipgloss.NewStyle().Foreground(lipgloss.Color("39")),
+	}
+}
+
+// highContrastTheme uses only black and white plus bright accents, picked
+// per terminal background, and shows focus with reverse video.
+func highContrastTheme() theme {
+	fg := lipgloss.AdaptiveColor{Light: "0", Dark: "15"}
+	plain := lipgloss.NewStyle().Foreground(fg)
+	return theme{
+		focused:          plain.Bold(true).Reverse(true),
+		blurred:          plain,
+		warning:          lipgloss.NewStyle().Bold(true).Foreground(lipgloss.AdaptiveColor{Light: "1", Dark: "9"}),
+		cursor:           plain.Bold(true).Reverse(true),
+		marked:           lipgloss.NewStyle().Bold(true).Foreground(lipgloss.AdaptiveColor{Light: "4", Dark: "11"}),
+		title:            plain.Bold(true).Reverse(true),
+		listTitle:        plain.Bold(true).Reverse(true).Padding(0, 1),
+		listTitleBlurred: plain.Bold(true).Padding(0, 1),
+		gitModified:      lipgloss.NewStyle().Bold(true).Foreground(lipgloss.AdaptiveColor{Light: "3", Dark: "11"}),
+		gitStaged:        lipgloss.NewStyle().Bold(true).Foreground(lipgloss.AdaptiveColor{Light: "2", Dark: "10"}),
+		gitUntracked:     lipgloss.NewStyle().Bold(true).Foreground(lipgloss.AdaptiveColor{Light: "4", Dark: "14"}),
+		separator:        true,
+	}
+}
+
+var themes = map[string]func() theme{
+	"default":       defaultTheme,
+	"high-contrast": highContrastTheme,
+}
+
+// applyTheme installs the named theme. background forces the dark or light
+// variant of adaptive colors; "auto" asks the terminal.
+func applyTheme(name, background string) error {
+	mk, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q: want default or high-contrast", name)
+	}
+	switch background {
+	case "auto":
+	case "dark":
+		lipgloss.SetHasDarkBackground(true)
+	case "light":
+		lipgloss.SetHasDarkBackground(false)
+	default:
+		return fmt.Errorf("invalid --background %q: want auto, dark or light", background)
+	}
+	ui = mk()
+	focusedStyle = ui.focused
+	blurredStyle = ui.blurred
+	warningStyle = ui.warning
+	cursorStyle = ui.cursor
+	markedStyle = ui.marked
+	titleStyle = ui.title
+	focusedButton = focusedStyle.Render("[ Copy ]")
+	blurredButton = blurredStyle.Render("[ Copy ]")
+	return nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// estimateTokens approximates the token count of s at about four bytes
+// per token, which is close enough for English text and code.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// formatCount renders a token or byte count compactly, e.g. 950, 12k, 1.3M.
+func formatCount(n int) string {
+	switch {
+	case n < 1000:
+		return fmt.Sprint(n)
+	case n < 1_000_000:
+		return fmt.Sprintf("%dk", (n+500)/1000)
+	default:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	}
+}
+
+// tokenizers count tokens the way a family of models does, by name:
+// OpenAI's BPE encodings, loaded from the embedded tables on first use, an
+// estimate for Claude, whose tokenizer isn't published, and the plain
+// four-bytes-per-token heuristic.
+var tokenizers = map[string]func(string) int{
+	"cl100k_base": bpeCounter("cl100k_base"),
+	"o200k_base":  bpeCounter("o200k_base"),
+	"claude":      estimateClaudeTokens,
+	"chars":       estimateTokens,
+}
+
+// modelTokenizers maps model name prefixes to tokenizers, most specific
+// first, so --model can name the target model instead.
+var modelTokenizers = []struct{ prefix, tokenizer string }{
+	{"gpt-4o", "o200k_base"},
+	{"gpt-4.1", "o200k_base"},
+	{"gpt-5", "o200k_base"},
+	{"o1", "o200k_base"},
+	{"o3", "o200k_base"},
+	{"o4", "o200k_base"},
+	{"gpt-4", "cl100k_base"},
+	{"gpt-3.5", "cl100k_base"},
+	{"claude", "claude"},
+}
+
+// tokenizerFor returns the name of the tokenizer for model, which is a
+// tokenizer name or a model name.
+func tokenizerFor(model string) (string, bool) {
+	if _, ok := tokenizers[model]; ok {
+		return model, true
+	}
+	for _, mt := range modelTokenizers {
+		if strings.HasPrefix(model, mt.prefix) {
+			return mt.tokenizer, true
+		}
+	}
+	return "", false
+}
+
+// countTokens counts the tokens of s with the tokenizer for --model.
+func (o options) countTokens(s string) int {
+	name, _ := tokenizerFor(o.Model)
+	if count, ok := tokenizers[name]; ok {
+		return count(s)
+	}
+	return estimateTokens(s)
+}
+
+// bpeCounter counts with the named encoding, falling back to
+// estimateTokens if it can't be loaded.
+func bpeCounter(encoding string) func(string) int {
+	load := sync.OnceValues(func() (*tiktoken.Tiktoken, error) {
+		setBpeLoader()
+		return tiktoken.GetEncoding(encoding)
+	})
+	return func(s string) int {
+		enc, err := load()
+		if err != nil {
+			return estimateTokens(s)
+		}
+		return len(enc.EncodeOrdinary(s))
+	}
+}
+
+var setBpeLoader = sync.OnceFunc(func() {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+})
+
+// estimateClaudeTokens approximates Claude's tokenizer, which splits code
+// more finely than cl100k_base, at about 3.5 bytes per token.
+func estimateClaudeTokens(s string) int {
+	return (len(s)*2 + 6) / 7
+}
+
+// tokenDebounce is how long the selection and request must stay unchanged
+// before the prompt is rebuilt and counted.
+const tokenDebounce = 300 * time.Millisecond
+
+// tokenState tracks the token count of the prompt the current selection and
+// request would produce. key identifies what was last counted; seq
+// discards ticks and counts for older keys.
+type tokenState struct {
+	key      string
+	seq      int
+	count    int
+	stats    selectionStats
+	counted  bool
+	counting bool
+}
+
+// selectionStats totals the selected files as they are on disk; lines
+// counts text files only.
+type selectionStats struct {
+	bytes int64
+	lines int
+}
+
+type tokenTickMsg struct{ seq int }
+
+type tokenCountMsg struct {
+	seq   int
+	count int
+	stats selectionStats
+}
+
+// tokenKey identifies the inputs of the prompt that change as the user
+// works: the selection, its line ranges and the request.
+func (m model) tokenKey() string {
+	var sb strings.Builder
+	for p, r := range selectedRanges(m.root) {
+		fmt.Fprintf(&sb, "%s:%d-%d\n", p, r.start, r.end)
+	}
+	keys := strings.Split(sb.String(), "\n")
+	slices.Sort(keys)
+	return strings.Join(selectedFiles(m.root), "\n") + "\x00" + strings.Join(keys, "\n") + "\x00" + m.textarea.Value()
+}
+
+// recount schedules a count once the prompt's inputs settle.
+func (m *model) recount() tea.Cmd {
+	if m.root == nil {
+		return nil
+	}
+	key := m.tokenKey()
+	if key == m.tokens.key {
+		return nil
+	}
+	m.tokens.key = key
+	m.tokens.seq++
+	m.tokens.counting = true
+	seq := m.tokens.seq
+	return tea.Tick(tokenDebounce, func(time.Time) tea.Msg { return tokenTickMsg{seq: seq} })
+}
+
+// mayChangeSelection reports whether msg can change what is selected:
+// keys, the mouse, the control file and changes on disk.
+func mayChangeSelection(msg tea.Msg) bool {
+	switch msg.(type) {
+	case tea.KeyMsg, tea.MouseMsg, controlMsg, fsEventMsg:
+		return true
+	}
+	return false
+}
+
+// countPrompt builds the prompt and counts it in the background, from a
+// copy of the tree so the UI can keep changing it. Commands are left out,
+// so counting doesn't run them on every change.
+func (m model) countPrompt(seq int) tea.Cmd {
+	opts := m.opts
+	opts.Commands = nil
+	root := m.root.snapshot(nil)
+	files := selectedFiles(root)
+	request := m.textarea.Value()
+	return func() tea.Msg {
+		prompt := buildPrompt(root, files, request, opts)
+		return tokenCountMsg{seq: seq, count: opts.countTokens(prompt), stats: measureFiles(files)}
+	}
+}
+
+// snapshot copies the loaded part of the tree under n, which becomes a
+// child of parent.
+func (n *node) snapshot(parent *node) *node {
+	c := *n
+	c.parent = parent
+	c.children = make([]*node, len(n.children))
+	for i, child := range n.children {
+		c.children[i] = child.snapshot(&c)
+	}
+	return &c
+}
+
+// measureFiles totals the size and lines of files.
+func measureFiles(files []string) selectionStats {
+	var st selectionStats
+	for _, p := range files {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		st.bytes += int64(len(b))
+		if len(b) == 0 || bytes.IndexByte(b, 0) >= 0 {
+			continue
+		}
+		st.lines += bytes.Count(b, []byte("\n"))
+		if b[len(b)-1] != '\n' {
+			st.lines++
+		}
+	}
+	return st
+}
+
+// statusBar sums up the selection: files, then bytes, lines and tokens
+// from the last count.
+func (m model) statusBar() string {
+	files := len(selectedFiles(m.root))
+	s := fmt.Sprintf("%d files selected", files)
+	if files == 1 {
+		s = "1 file selected"
+	}
+	if m.tokens.counted {
+		s += fmt.Sprintf(" · %s bytes · %s lines · %s", formatCount(int(m.tokens.stats.bytes)), formatCount(m.tokens.stats.lines), m.tokenStatus())
+	}
+	return s
+}
+
+// tokenStatus describes the prompt's size next to the accept button,
+// against the --budget if there is one.
+func (m model) tokenStatus() string {
+	if !m.tokens.counted {
+		return "counting tokens…"
+	}
+	s := formatCount(m.tokens.count)
+	if m.opts.Budget > 0 {
+		s += " / " + formatCount(m.opts.Budget)
+	}
+	s += " tokens"
+	if m.overBudget() {
+		s += " (over budget)"
+	}
+	if m.tokens.counting {
+		s += " (counting…)"
+	}
+	return s
+}
+
+// overBudget reports whether the last count exceeds the --budget.
+func (m model) overBudget() bool {
+	return m.opts.Budget > 0 && m.tokens.counted && m.tokens.count > m.opts.Budget
+}
+
+// budgetError explains why prompt can't be copied under --strict-budget,
+// or returns "" if it fits. It counts prompt itself, since the last
+// background count may be stale.
+func (m model) budgetError(prompt string) string {
+	if !m.opts.StrictBudget || m.opts.Budget <= 0 {
+		return ""
+	}
+	if n := m.opts.countTokens(prompt); n > m.opts.Budget {
+		return fmt.Sprintf("The prompt is %s tokens, over the budget of %s. Deselect files until it fits.", formatCount(n), formatCount(m.opts.Budget))
+	}
+	return ""
+}
+
+// fileTokens is the cached token count of a file, valid while its size and
+// modification time are unchanged.
+type fileTokens struct {
+	modTime time.Time
+	size    int64
+	count   int
+	// binary files have no meaningful count.
+	binary  bool
+	pending bool
+}
+
+// fileTokensMsg delivers counts for the files in the tree's visible page.
+type fileTokensMsg map[string]fileTokens
+
+// visiblePage identifies the files on the tree's current page, and
+// whether their counts are shown, so they are only counted again when it
+// changes.
+func (m model) visiblePage() string {
+	if !m.delegate.treeTokens {
+		return ""
+	}
+	items := m.list.VisibleItems()
+	start, end := m.list.Paginator.GetSliceBounds(len(items))
+	var sb strings.Builder
+	for _, li := range items[start:end] {
+		if i, ok := li.(item); ok && !i.more {
+			sb.WriteString(i.node.path + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// countVisibleFiles counts the tokens of the files on the tree's current
+// page whose counts are missing or stale, in the background.
+func (m *model) countVisibleFiles() tea.Cmd {
+	if !m.delegate.treeTokens {
+		return nil
+	}
+	items := m.list.VisibleItems()
+	start, end := m.list.Paginator.GetSliceBounds(len(items))
+	opts := m.opts
+	jobs := map[string]fileTokens{}
+	for _, li := range items[start:end] {
+		i, ok := li.(item)
+		if !ok || i.more || i.node.isDir {
+			continue
+		}
+		p := i.node.path
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		cached := m.fileTokens[p]
+		if cached.pending || cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			continue
+		}
+		m.fileTokens[p] = fileTokens{modTime: cached.modTime, size: cached.size, count: cached.count, binary: cached.binary, pending: true}
+		jobs[p] = fileTokens{modTime: info.ModTime(), size: info.Size()}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	return func() tea.Msg {
+		for p, ft := range jobs {
+			b, err := os.ReadFile(p)
+			switch {
+			case err != nil:
+				continue
+			case bytes.IndexByte(b, 0) >= 0:
+				ft.binary = true
+			case len(b) > searchMaxFileSize:
+				// too slow to encode on every change; estimate instead
+				ft.count = estimateTokens(string(b))
+			default:
+				ft.count = opts.countTokens(string(b))
+			}
+			jobs[p] = ft
+		}
+		return fileTokensMsg(jobs)
+	}
+}
+
+// label renders a cached count for the tree, padded to a fixed width.
+func (ft fileTokens) label() string {
+	s := ""
+	switch {
+	case ft.binary:
+		s = "bin"
+	case ft.modTime.IsZero():
+		s = "…"
+	default:
+		s = "~" + formatCount(ft.count)
+	}
+	return fmt.Sprintf(" %6s", s)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// transcriptsDir is where answers are kept, under the root.
+const transcriptsDir = ".ctx-tui/transcripts"
+
+// saveTranscript writes an exchange to a new Markdown file named for when
+// it was answered and by which model, and returns its path.
+func saveTranscript(root string, e exchange, at time.Time) (string, error) {
+	dir := filepath.Join(root, filepath.FromSlash(transcriptsDir))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	title, _, _ := strings.Cut(strings.TrimSpace(e.request), "\n")
+	if title == "" {
+		title = "Untitled request"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n- Model: %s\n- Date: %s\n\n", title, e.model, at.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "## Request\n\n%s\n\n## Response\n\n%s\n", strings.TrimSpace(e.asked.Content), strings.TrimSpace(e.answer))
+	model := strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || r == filepath.Separator {
+			return '-'
+		}
+		return r
+	}, e.model)
+	base := at.Format("2006-01-02T150405") + "-" + model
+	// answers within the same second get numbered
+	for i := 1; ; i++ {
+		path := filepath.Join(dir, base+".md")
+		if i > 1 {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d.md", base, i))
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.WriteString(sb.String()); err != nil {
+			f.Close()
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// trimStep drops a file from the selection, or with keep set, cuts it to
+// its first keep lines.
+type trimStep struct {
+	node   *node
+	tokens int
+	keep   int
+}
+
+// trimCandidate is a selected file with the tokens and lines it emits.
+type trimCandidate struct {
+	node   *node
+	tokens int
+	lines  int
+}
+
+// offerTrim works out how to bring the prompt within --budget using
+// --trim-strategy and asks before changing the selection.
+func (m *model) offerTrim() tea.Cmd {
+	if m.opts.Budget <= 0 {
+		return m.flash("Set --budget to trim the selection to it")
+	}
+	opts := m.opts
+	opts.Commands = nil
+	total := opts.countTokens(buildPrompt(m.root, selectedFiles(m.root), m.textarea.Value(), opts))
+	if total <= m.opts.Budget {
+		return m.flash(fmt.Sprintf("The prompt fits the budget: %s of %s tokens", formatCount(total), formatCount(m.opts.Budget)))
+	}
+	steps, ok := planTrim(m.trimCandidates(), total-m.opts.Budget, m.opts.TrimStrategy)
+	if !ok {
+		return m.flash("Can't fit the budget by trimming files; shorten the request or raise --budget")
+	}
+	m.confirm = &confirmation{
+		prompt: fmt.Sprintf("Fit %s tokens into %s: %s?", formatCount(total), formatCount(m.opts.Budget), m.describeTrim(steps)),
+		accept: func(m *model) tea.Cmd {
+			applyTrim(steps)
+			m.reflatten()
+			if m.focus == acceptView {
+				m.renderReview()
+			}
+			return m.flash(fmt.Sprintf("Trimmed %d files to fit the budget", len(steps)))
+		},
+	}
+	return nil
+}
+
+// trimCandidates counts what each selected file contributes, in emission
+// order.
+func (m model) trimCandidates() []trimCandidate {
+	byPath := map[string]*node{}
+	for _, n := range selectedNodes(m.root) {
+		byPath[n.path] = n
+	}
+	var cands []trimCandidate
+	for _, p := range orderFiles(m.root.path, selectedFiles(m.root), m.opts.First) {
+		n := byPath[p]
+		f := promptFile{path: p}
+		f.read(n.lines, m.opts)
+		c := trimCandidate{node: n, tokens: m.opts.countTokens(f.path + f.content)}
+		if f.text {
+			c.lines = strings.Count(f.content, "\n") + 1
+		}
+		cands = append(cands, c)
+	}
+	return cands
+}
+
+// planTrim picks files to drop until excess tokens are gone: the largest
+// first, or the last emitted (the lowest priority) first. The truncate
+// strategy goes largest first too but cuts the file that crosses the line
+// instead of dropping it. ok is false if dropping everything isn't enough.
+func planTrim(cands []trimCandidate, excess int, strategy string) (steps []trimStep, ok bool) {
+	cands = slices.Clone(cands)
+	if strategy == "last" {
+		slices.Reverse(cands)
+	} else {
+		slices.SortStableFunc(cands, func(a, b trimCandidate) int { return b.tokens - a.tokens })
+	}
+	for _, c := range cands {
+		if excess <= 0 {
+			break
+		}
+		if strategy == "truncate" && c.tokens > excess && c.lines > 1 {
+			if keep := c.lines * (c.tokens - excess) / c.tokens; keep > 0 {
+				steps = append(steps, trimStep{node: c.node, tokens: excess, keep: keep})
+				excess = 0
+				break
+			}
+		}
+		steps = append(steps, trimStep{node: c.node, tokens: c.tokens})
+		excess -= c.tokens
+	}
+	return steps, excess <= 0
+}
+
+// applyTrim drops and cuts the files of steps.
+func applyTrim(steps []trimStep) {
+	for _, s := range steps {
+		if s.keep == 0 {
+			s.node.selected = false
+			continue
+		}
+		start := max(s.node.lines.start, 1)
+		s.node.lines = lineRange{start: start, end: start + s.keep - 1}
+	}
+}
+
+// describeTrim lists the first few steps, e.g. "drop a.go (12k), cut
+// b.go to 80 lines and 3 more".
+func (m model) describeTrim(steps []trimStep) string {
+	const shown = 3
+	var parts []string
+	for _, s := range steps[:min(len(steps), shown)] {
+		p := displayPath(m.root.path, m.opts.PathBase, s.node.path)
+		if s.keep > 0 {
+			parts = append(parts, fmt.Sprintf("cut %s to %d lines", p, s.keep))
+		} else {
+			parts = append(parts, fmt.Sprintf("drop %s (%s)", p, formatCount(s.tokens)))
+		}
+	}
+	desc := strings.Join(parts, ", ")
+	if len(steps) > shown {
+		desc += fmt.Sprintf(" and %d more", len(steps)-shown)
+	}
+	return desc
+}